@@ -0,0 +1,133 @@
+package ttlmap
+
+// TypedEntry is an item to set with SetMany.
+type TypedEntry[K comparable, V any] struct {
+	Key     K
+	Item    TypedItem[V]
+	Options *SetOptions
+}
+
+// Entry is an item to set on a Map with SetMany.
+type Entry = TypedEntry[string, interface{}]
+
+// SetMany sets the entries like Set, taking the lock of each shard once, and
+// returns the error of each entry in the same order. The expiry scheduling is
+// updated at most once per shard.
+func (m *TypedMap[K, V]) SetMany(entries []TypedEntry[K, V]) []error {
+	errs := make([]error, len(entries))
+	m.batch(len(entries), func(i int) K { return entries[i].Key }, func(s *shard[K, V], i int) {
+		item := entries[i].Item
+		errs[i] = s.set(entries[i].Key, &item, entries[i].Options)
+	}, func(i int) {
+		errs[i] = ErrDrained
+	})
+	return errs
+}
+
+// GetMany returns the items with the given keys like Get, taking the lock of
+// each shard once, along with the error of each key in the same order.
+func (m *TypedMap[K, V]) GetMany(keys []K) ([]TypedItem[V], []error) {
+	items := make([]TypedItem[V], len(keys))
+	errs := make([]error, len(keys))
+	for si, indices := range m.group(len(keys), func(i int) K { return keys[i] }) {
+		if len(indices) > 0 {
+			m.shards[si].getMany(keys, indices, items, errs)
+		}
+	}
+	return items, errs
+}
+
+// DeleteMany deletes the items with the given keys like Delete, taking the
+// lock of each shard once, and returns the deleted items along with the error
+// of each key in the same order.
+func (m *TypedMap[K, V]) DeleteMany(keys []K) ([]TypedItem[V], []error) {
+	items := make([]TypedItem[V], len(keys))
+	errs := make([]error, len(keys))
+	m.batch(len(keys), func(i int) K { return keys[i] }, func(s *shard[K, V], i int) {
+		if pqi := s.lookup(keys[i]); pqi != nil {
+			s.delete(pqi)
+			items[i] = *pqi.item
+		} else {
+			errs[i] = ErrNotExist
+		}
+	}, func(i int) {
+		errs[i] = ErrDrained
+	})
+	return items, errs
+}
+
+// batch calls fn for each of the n keys with the write lock of its shard
+// held, or drained if the shard is drained.
+func (m *TypedMap[K, V]) batch(n int, key func(i int) K, fn func(s *shard[K, V], i int), drained func(i int)) {
+	for si, indices := range m.group(n, key) {
+		if len(indices) == 0 {
+			continue
+		}
+		s := m.shards[si]
+		s.store.Lock()
+		for _, i := range indices {
+			if s.keeper.drained {
+				drained(i)
+			} else {
+				fn(s, i)
+			}
+		}
+		s.store.unlock()
+	}
+}
+
+// group returns the indices of the n keys assigned to each shard, in order.
+func (m *TypedMap[K, V]) group(n int, key func(i int) K) [][]int {
+	groups := make([][]int, len(m.shards))
+	if len(m.shards) == 1 {
+		groups[0] = make([]int, n)
+		for i := range groups[0] {
+			groups[0][i] = i
+		}
+		return groups
+	}
+	for i := 0; i < n; i++ {
+		si := m.shardIndex(key(i))
+		groups[si] = append(groups[si], i)
+	}
+	return groups
+}
+
+// getMany looks up the keys at the given indices under the read lock, then
+// under the write lock for the items that have to be expired on the spot or
+// whose sliding expiration has to be refreshed.
+func (s *shard[K, V]) getMany(keys []K, indices []int, items []TypedItem[V], errs []error) {
+	var locked []int
+	s.store.RLock()
+	for _, i := range indices {
+		if s.keeper.drained {
+			errs[i] = ErrDrained
+		} else if pqi := s.store.kv[keys[i]]; pqi == nil {
+			errs[i] = ErrNotExist
+		} else if pqi.sliding > 0 || (s.store.strict && s.store.expired(pqi.item)) {
+			locked = append(locked, i)
+			continue
+		} else {
+			s.store.access(pqi)
+			items[i] = *pqi.item
+		}
+		s.store.stats.countGet(errs[i])
+	}
+	s.store.RUnlock()
+	if len(locked) == 0 {
+		return
+	}
+	s.store.Lock()
+	for _, i := range locked {
+		if s.keeper.drained {
+			errs[i] = ErrDrained
+		} else if pqi := s.lookup(keys[i]); pqi == nil {
+			errs[i] = ErrNotExist
+		} else {
+			s.read(pqi)
+			items[i] = *pqi.item
+		}
+		s.store.stats.countGet(errs[i])
+	}
+	s.store.unlock()
+}
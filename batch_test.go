@@ -0,0 +1,132 @@
+package ttlmap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMapSetMany(t *testing.T) {
+	m := New(&Options{Shards: 4})
+	defer m.Drain()
+	if err := m.Set("0", NewItem("old", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	nx := &SetOptions{KeyExist: KeyExistNotYet}
+	entries := make([]Entry, 10)
+	for i := range entries {
+		entries[i] = Entry{
+			Key:     fmt.Sprintf("%d", i),
+			Item:    NewItem(i, WithTTL(time.Hour)),
+			Options: nx,
+		}
+	}
+	errs := m.SetMany(entries)
+	for i, err := range errs {
+		if i == 0 && err != ErrExist {
+			t.Fatalf("Expecting ErrExist for 0, got %v", err)
+		} else if i > 0 && err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.Len(); n != 10 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	if item, err := m.Get("0"); err != nil || item.Value() != "old" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
+
+func TestMapGetMany(t *testing.T) {
+	m := New(&Options{Shards: 4, Stats: true})
+	defer m.Drain()
+	testMapSetN(t, m, 5, time.Hour)
+	items, errs := m.GetMany([]string{"4", "5", "0"})
+	if errs[0] != nil || items[0].Value() != "value" {
+		t.Fatalf("Invalid item=%v err=%v", items[0], errs[0])
+	}
+	if errs[1] != ErrNotExist {
+		t.Fatal(errs[1])
+	}
+	if errs[2] != nil || items[2].Value() != "value" {
+		t.Fatalf("Invalid item=%v err=%v", items[2], errs[2])
+	}
+	if stats := m.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("Invalid stats: %+v", stats)
+	}
+}
+
+func TestMapGetManySliding(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := New(&Options{Clock: clock, SlidingExpiration: true})
+	defer m.Drain()
+	if err := m.Set("foo", NewItem("bar", WithTTLFrom(clock, time.Minute)), nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	items, errs := m.GetMany([]string{"foo"})
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if ttl := items[0].TTLAt(clock.Now()); ttl != time.Minute {
+		t.Fatalf("Invalid TTL: %v", ttl)
+	}
+}
+
+func TestMapDeleteMany(t *testing.T) {
+	m := New(&Options{Shards: 4})
+	defer m.Drain()
+	testMapSetN(t, m, 5, time.Hour)
+	items, errs := m.DeleteMany([]string{"1", "9", "3"})
+	if errs[0] != nil || items[0].Value() != "value" {
+		t.Fatalf("Invalid item=%v err=%v", items[0], errs[0])
+	}
+	if errs[1] != ErrNotExist {
+		t.Fatal(errs[1])
+	}
+	if errs[2] != nil {
+		t.Fatal(errs[2])
+	}
+	if n := m.Len(); n != 3 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+}
+
+func TestMapBatchDrained(t *testing.T) {
+	m := New(nil)
+	m.Drain()
+	if errs := m.SetMany([]Entry{{Key: "foo", Item: NewItem("bar", nil)}}); errs[0] != ErrDrained {
+		t.Fatal(errs[0])
+	}
+	if _, errs := m.GetMany([]string{"foo"}); errs[0] != ErrDrained {
+		t.Fatal(errs[0])
+	}
+	if _, errs := m.DeleteMany([]string{"foo"}); errs[0] != ErrDrained {
+		t.Fatal(errs[0])
+	}
+}
+
+// BenchmarkMapSetMany replaces items in batches of 100, reporting the time
+// per item like BenchmarkMapSet1 does for single Set calls.
+func BenchmarkMapSetMany(b *testing.B) {
+	b.StopTimer()
+	m := New(nil)
+	entries := make([]Entry, 100)
+	for i := range entries {
+		entries[i] = Entry{Key: fmt.Sprintf("%d", i), Item: NewItem("bar", WithTTL(30*time.Minute))}
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i += len(entries) {
+		batch := entries
+		if n := b.N - i; n < len(batch) {
+			batch = batch[:n]
+		}
+		for _, err := range m.SetMany(batch) {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+	m.Drain()
+}
@@ -0,0 +1,374 @@
+package ttlmap
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// BytesOptions for initializing a new BytesMap.
+type BytesOptions struct {
+	// Capacity is the total size in bytes of the arenas holding the items,
+	// divided evenly between shards. Each item takes 24 bytes on top of its
+	// key and value. When an arena is full, the oldest items of its shard
+	// are evicted. Defaults to 64 MiB.
+	Capacity int
+	// Shards splits the map into independent partitions, each with its own
+	// lock and arena. Zero or one means a single shard.
+	Shards int
+	// SweepInterval is how often expired items are removed from the map.
+	// Defaults to one second.
+	SweepInterval time.Duration
+	// Sweep bounds the work done by each batch of the sweep while holding
+	// the lock of a shard. If nil, batches check up to 1024 items.
+	Sweep *SweepOptions
+	// Clock provides the time used for expiration. If nil, SystemClock is
+	// used.
+	Clock Clock
+}
+
+const (
+	defaultBytesCapacity = 64 << 20
+	defaultBytesSweep    = 1024
+	// Items are stored as a header of the key hash, expiration, key length
+	// with flags and value length, followed by the key and the value.
+	bytesHeaderSize = 24
+	bytesDeleted    = 1 << 31
+	bytesExpires    = 1 << 30
+	bytesKeyLenMask = bytesExpires - 1
+)
+
+// BytesMap is a map of []byte values with expirable items, for caches holding
+// millions of items.
+//
+// Unlike Map, it holds no pointer per item, so the garbage collector does not
+// have to scan its items. Items are appended to a pre-allocated ring buffer
+// per shard and indexed by a hash of their key; expirations are stored along
+// with them and checked when they are read. Expired items are removed
+// periodically without callbacks, and the oldest items are evicted when a
+// ring is full. Two keys with the same 64-bit hash evict each other.
+type BytesMap struct {
+	shards        []*bytesShard
+	seed          maphash.Seed
+	clock         Clock
+	interval      time.Duration
+	sweepSize     int
+	sweepDuration time.Duration
+	timer         Timer
+	drainOnce     sync.Once
+}
+
+type bytesShard struct {
+	sync.RWMutex
+	// index maps key hashes to the offset of the item in the arena.
+	index map[uint64]uint64
+	// arena is the ring buffer of the items, from tail to head. It is nil
+	// once the map is drained.
+	arena []byte
+	// head, tail and cursor are offsets in the bytes ever written to the
+	// arena, at the arena position modulo its size. cursor is where the
+	// sweep resumes.
+	head   uint64
+	tail   uint64
+	cursor uint64
+}
+
+type bytesHeader struct {
+	hash       uint64
+	expiration int64
+	keyLen     uint32
+	valueLen   uint32
+}
+
+func (h *bytesHeader) size() int {
+	return bytesHeaderSize + int(h.keyLen&bytesKeyLenMask) + int(h.valueLen)
+}
+
+// NewBytes creates a new BytesMap with given options.
+func NewBytes(opts *BytesOptions) *BytesMap {
+	if opts == nil {
+		opts = &BytesOptions{}
+	}
+	n := opts.Shards
+	if n < 1 {
+		n = 1
+	}
+	capacity := opts.Capacity
+	if capacity <= 0 {
+		capacity = defaultBytesCapacity
+	}
+	m := &BytesMap{
+		shards:   make([]*bytesShard, n),
+		seed:     maphash.MakeSeed(),
+		clock:    opts.Clock,
+		interval: opts.SweepInterval,
+	}
+	if m.clock == nil {
+		m.clock = SystemClock{}
+	}
+	if m.interval <= 0 {
+		m.interval = time.Second
+	}
+	m.sweepSize, m.sweepDuration = opts.Sweep.limits()
+	if m.sweepSize <= 0 && m.sweepDuration <= 0 {
+		m.sweepSize = defaultBytesSweep
+	}
+	for i := range m.shards {
+		m.shards[i] = &bytesShard{
+			index: make(map[uint64]uint64),
+			arena: make([]byte, (capacity+n-1)/n),
+		}
+	}
+	m.timer = m.clock.AfterFunc(m.interval, m.sweep)
+	return m
+}
+
+// Len returns the number of items in the map, including the expired items
+// not removed yet.
+func (m *BytesMap) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.RLock()
+		n += len(s.index)
+		s.RUnlock()
+	}
+	return n
+}
+
+// Get returns a copy of the value of the item with the given key.
+// ErrNotExist will be returned if the key does not exist or the item expired.
+// ErrDrained will be returned if the map is already drained.
+func (m *BytesMap) Get(key string) ([]byte, error) {
+	hash := maphash.String(m.seed, key)
+	s := m.shard(hash)
+	s.RLock()
+	defer s.RUnlock()
+	if s.arena == nil {
+		return nil, ErrDrained
+	}
+	pos, h, ok := s.lookup(hash, key, m.clock.Now())
+	if !ok {
+		return nil, ErrNotExist
+	}
+	value := make([]byte, h.valueLen)
+	s.read(pos+bytesHeaderSize+int(h.keyLen&bytesKeyLenMask), value)
+	return value, nil
+}
+
+// Set assigns a value with the specified key and expiration in the map,
+// copying the value. A nil expiration means the item never expires.
+// ErrTooLarge will be returned if the item does not fit in an arena.
+// ErrDrained will be returned if the map is already drained.
+func (m *BytesMap) Set(key string, value []byte, expiration *time.Time) error {
+	hash := maphash.String(m.seed, key)
+	s := m.shard(hash)
+	s.Lock()
+	defer s.Unlock()
+	if s.arena == nil {
+		return ErrDrained
+	}
+	h := bytesHeader{
+		hash:     hash,
+		keyLen:   uint32(len(key)),
+		valueLen: uint32(len(value)),
+	}
+	if len(key) > bytesKeyLenMask || h.size() > len(s.arena) {
+		return ErrTooLarge
+	}
+	if expiration != nil {
+		h.keyLen |= bytesExpires
+		h.expiration = expiration.UnixNano()
+	}
+	if pos, ok := s.index[hash]; ok {
+		s.remove(hash, int(pos))
+	}
+	for s.head-s.tail+uint64(h.size()) > uint64(len(s.arena)) {
+		s.evictOldest()
+	}
+	pos := s.pos(s.head)
+	var header [bytesHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[0:], h.hash)
+	binary.LittleEndian.PutUint64(header[8:], uint64(h.expiration))
+	binary.LittleEndian.PutUint32(header[16:], h.keyLen)
+	binary.LittleEndian.PutUint32(header[20:], h.valueLen)
+	s.write(pos, header[:])
+	s.writeString(pos+bytesHeaderSize, key)
+	s.write(pos+bytesHeaderSize+len(key), value)
+	s.index[hash] = uint64(pos)
+	s.head += uint64(h.size())
+	return nil
+}
+
+// Delete deletes the item with the specified key from the map.
+// ErrNotExist will be returned if the key does not exist or the item expired.
+// ErrDrained will be returned if the map is already drained.
+func (m *BytesMap) Delete(key string) error {
+	hash := maphash.String(m.seed, key)
+	s := m.shard(hash)
+	s.Lock()
+	defer s.Unlock()
+	if s.arena == nil {
+		return ErrDrained
+	}
+	pos, _, ok := s.lookup(hash, key, m.clock.Now())
+	if !ok {
+		if pos, ok := s.index[hash]; ok && s.expired(int(pos), m.clock.Now()) {
+			s.remove(hash, int(pos))
+		}
+		return ErrNotExist
+	}
+	s.remove(hash, pos)
+	return nil
+}
+
+// Drain removes all items from the map and terminates the usage of this map.
+func (m *BytesMap) Drain() {
+	m.drainOnce.Do(func() {
+		m.timer.Stop()
+		for _, s := range m.shards {
+			s.Lock()
+			s.index = nil
+			s.arena = nil
+			s.Unlock()
+		}
+	})
+}
+
+func (m *BytesMap) shard(hash uint64) *bytesShard {
+	return m.shards[hash%uint64(len(m.shards))]
+}
+
+// sweep removes the expired items and reschedules itself. Each shard is swept
+// from the oldest item to the newest in batches, releasing its lock between
+// them.
+func (m *BytesMap) sweep() {
+	for _, s := range m.shards {
+		s.Lock()
+		if s.arena == nil {
+			s.Unlock()
+			return
+		}
+		end := s.head
+		s.cursor = s.tail
+		for s.sweep(end, m.sweepSize, m.sweepDuration, m.clock.Now()) {
+			s.Unlock()
+			s.Lock()
+			if s.arena == nil {
+				s.Unlock()
+				return
+			}
+		}
+		s.Unlock()
+	}
+	m.timer.Reset(m.interval)
+}
+
+// sweep removes the expired items from the cursor to end, stopping after size
+// items or once it ran for d, if they are positive. It reports whether items
+// remain.
+func (s *bytesShard) sweep(end uint64, size int, d time.Duration, now time.Time) bool {
+	start := time.Now()
+	// Items before the tail were evicted since the last batch.
+	s.cursor = max(s.cursor, s.tail)
+	for n := 0; s.cursor < end; n++ {
+		if (size > 0 && n >= size) || (d > 0 && time.Since(start) >= d) {
+			return true
+		}
+		pos := s.pos(s.cursor)
+		h := s.header(pos)
+		if h.keyLen&bytesDeleted == 0 && s.expired(pos, now) {
+			s.remove(h.hash, pos)
+		}
+		s.cursor += uint64(h.size())
+	}
+	return false
+}
+
+// lookup returns the offset and header of the live item with the given key.
+func (s *bytesShard) lookup(hash uint64, key string, now time.Time) (int, bytesHeader, bool) {
+	p, ok := s.index[hash]
+	if !ok {
+		return 0, bytesHeader{}, false
+	}
+	pos := int(p)
+	h := s.header(pos)
+	if int(h.keyLen&bytesKeyLenMask) != len(key) || !s.equal(pos+bytesHeaderSize, key) {
+		return 0, h, false
+	}
+	if h.keyLen&bytesExpires != 0 && h.expiration < now.UnixNano() {
+		return 0, h, false
+	}
+	return pos, h, true
+}
+
+func (s *bytesShard) expired(pos int, now time.Time) bool {
+	h := s.header(pos)
+	return h.keyLen&bytesExpires != 0 && h.expiration < now.UnixNano()
+}
+
+// remove marks the item at pos as deleted. Its space is reclaimed when the
+// ring wraps around.
+func (s *bytesShard) remove(hash uint64, pos int) {
+	delete(s.index, hash)
+	var b [4]byte
+	s.read(pos+16, b[:])
+	binary.LittleEndian.PutUint32(b[:], binary.LittleEndian.Uint32(b[:])|bytesDeleted)
+	s.write(pos+16, b[:])
+}
+
+// evictOldest reclaims the space of the item at the tail of the ring.
+func (s *bytesShard) evictOldest() {
+	tail := s.pos(s.tail)
+	h := s.header(tail)
+	if h.keyLen&bytesDeleted == 0 {
+		if pos, ok := s.index[h.hash]; ok && int(pos) == tail {
+			delete(s.index, h.hash)
+		}
+	}
+	s.tail += uint64(h.size())
+}
+
+// pos returns the position in the arena of an offset.
+func (s *bytesShard) pos(off uint64) int {
+	return int(off % uint64(len(s.arena)))
+}
+
+func (s *bytesShard) header(pos int) bytesHeader {
+	var b [bytesHeaderSize]byte
+	s.read(pos, b[:])
+	return bytesHeader{
+		hash:       binary.LittleEndian.Uint64(b[0:]),
+		expiration: int64(binary.LittleEndian.Uint64(b[8:])),
+		keyLen:     binary.LittleEndian.Uint32(b[16:]),
+		valueLen:   binary.LittleEndian.Uint32(b[20:]),
+	}
+}
+
+// read copies the bytes at pos in the ring into b.
+func (s *bytesShard) read(pos int, b []byte) {
+	pos %= len(s.arena)
+	n := copy(b, s.arena[pos:])
+	copy(b[n:], s.arena)
+}
+
+// write copies b at pos in the ring.
+func (s *bytesShard) write(pos int, b []byte) {
+	pos %= len(s.arena)
+	n := copy(s.arena[pos:], b)
+	copy(s.arena, b[n:])
+}
+
+func (s *bytesShard) writeString(pos int, str string) {
+	pos %= len(s.arena)
+	n := copy(s.arena[pos:], str)
+	copy(s.arena, str[n:])
+}
+
+// equal reports whether the bytes at pos in the ring are str.
+func (s *bytesShard) equal(pos int, str string) bool {
+	pos %= len(s.arena)
+	end := min(pos+len(str), len(s.arena))
+	n := end - pos
+	return string(s.arena[pos:end]) == str[:n] && string(s.arena[:len(str)-n]) == str[n:]
+}
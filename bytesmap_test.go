@@ -0,0 +1,215 @@
+package ttlmap
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBytesMap(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := NewBytes(&BytesOptions{Clock: clock, Shards: 4})
+	defer m.Drain()
+	for i := 0; i < 100; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), []byte(fmt.Sprintf("value%d", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.Len(); n != 100 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	if err := m.Set("1", []byte("overwritten"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := m.Get("1"); err != nil || string(value) != "overwritten" {
+		t.Fatalf("Invalid value=%q err=%v", value, err)
+	}
+	if value, err := m.Get("2"); err != nil || string(value) != "value2" {
+		t.Fatalf("Invalid value=%q err=%v", value, err)
+	}
+	if err := m.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("2"); err != ErrNotExist {
+		t.Fatalf("Expecting ErrNotExist, got %v", err)
+	}
+	if err := m.Delete("2"); err != ErrNotExist {
+		t.Fatalf("Expecting ErrNotExist, got %v", err)
+	}
+	if n := m.Len(); n != 99 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+}
+
+func TestBytesMapExpiration(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := NewBytes(&BytesOptions{Clock: clock, SweepInterval: time.Minute})
+	defer m.Drain()
+	for i := 1; i <= 10; i++ {
+		expiration := clock.Now().Add(time.Duration(i) * time.Second)
+		if err := m.Set(fmt.Sprintf("%d", i), []byte{byte(i)}, &expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("never", []byte("never"), nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(5500 * time.Millisecond)
+	if _, err := m.Get("5"); err != ErrNotExist {
+		t.Fatalf("Expecting ErrNotExist, got %v", err)
+	}
+	if value, err := m.Get("6"); err != nil || !bytes.Equal(value, []byte{6}) {
+		t.Fatalf("Invalid value=%v err=%v", value, err)
+	}
+	// Expired items are only removed by the sweep.
+	if n := m.Len(); n != 11 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	clock.Advance(time.Minute)
+	if n := m.Len(); n != 1 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	if value, err := m.Get("never"); err != nil || string(value) != "never" {
+		t.Fatalf("Invalid value=%q err=%v", value, err)
+	}
+}
+
+func TestBytesMapSweepBatches(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := NewBytes(&BytesOptions{
+		Clock:         clock,
+		Capacity:      100 * (bytesHeaderSize + 3 + 1),
+		SweepInterval: time.Minute,
+		Sweep:         &SweepOptions{BatchSize: 7},
+	})
+	defer m.Drain()
+	// Twice as many items as fit, so the ring wraps and items in the middle
+	// expire first.
+	for i := 0; i < 200; i++ {
+		expiration := clock.Now().Add(time.Hour)
+		if i%3 == 0 {
+			expiration = clock.Now().Add(time.Second)
+		}
+		if err := m.Set(fmt.Sprintf("%03d", i), []byte{byte(i)}, &expiration); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.Len(); n != 100 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	clock.Advance(time.Minute)
+	if n := m.Len(); n != 67 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	for i := 100; i < 200; i++ {
+		_, err := m.Get(fmt.Sprintf("%03d", i))
+		if (i%3 == 0) != (err == ErrNotExist) {
+			t.Fatalf("Invalid error for %d: %v", i, err)
+		}
+	}
+}
+
+func TestBytesMapEviction(t *testing.T) {
+	const size = bytesHeaderSize + 2 + 10
+	m := NewBytes(&BytesOptions{Capacity: 10*size + 5})
+	defer m.Drain()
+	value := bytes.Repeat([]byte{'x'}, 10)
+	// The ring wraps around several times, splitting items at its end.
+	for i := 0; i < 95; i++ {
+		if err := m.Set(fmt.Sprintf("%02d", i), value, nil); err != nil {
+			t.Fatal(err)
+		}
+		if i%7 == 0 {
+			if err := m.Delete(fmt.Sprintf("%02d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 95; i++ {
+		key := fmt.Sprintf("%02d", i)
+		got, err := m.Get(key)
+		if i < 85 || i%7 == 0 {
+			if err != ErrNotExist {
+				t.Fatalf("Expecting ErrNotExist for %s, got %v", key, err)
+			}
+		} else if err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("Invalid value=%q err=%v for %s", got, err, key)
+		}
+	}
+	if n := m.Len(); n != 9 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	if err := m.Set("large", make([]byte, 10*size), nil); err != ErrTooLarge {
+		t.Fatalf("Expecting ErrTooLarge, got %v", err)
+	}
+}
+
+func TestBytesMapDrain(t *testing.T) {
+	m := NewBytes(nil)
+	if err := m.Set("key", []byte("value"), nil); err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+	if _, err := m.Get("key"); err != ErrDrained {
+		t.Fatalf("Expecting ErrDrained, got %v", err)
+	}
+	if err := m.Set("key", []byte("value"), nil); err != ErrDrained {
+		t.Fatalf("Expecting ErrDrained, got %v", err)
+	}
+	if n := m.Len(); n != 0 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+}
+
+// BenchmarkMapGC measures the duration of a garbage collection with 1M and
+// 10M items of 64 bytes in a Map and a BytesMap. The larger maps are skipped
+// unless -large is set.
+func BenchmarkMapGC(b *testing.B) {
+	for _, n := range []int{1000000, 10000000} {
+		b.Run(fmt.Sprintf("map/%d", n), func(b *testing.B) {
+			if n > 1000000 && !*large {
+				b.Skip("skipping large map without -large")
+			}
+			m := New(&Options{InitialCapacity: n})
+			for i := 0; i < n; i++ {
+				if err := m.Set(fmt.Sprintf("%d", i), NewItem(make([]byte, 64), WithTTL(time.Hour)), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			benchmarkGC(b)
+			m.Drain()
+		})
+		b.Run(fmt.Sprintf("bytes/%d", n), func(b *testing.B) {
+			if n > 1000000 && !*large {
+				b.Skip("skipping large map without -large")
+			}
+			m := NewBytes(&BytesOptions{Capacity: n * (bytesHeaderSize + 8 + 64), Shards: 16})
+			for i := 0; i < n; i++ {
+				if err := m.Set(fmt.Sprintf("%d", i), make([]byte, 64), WithTTL(time.Hour)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			benchmarkGC(b)
+			m.Drain()
+		})
+	}
+}
+
+// benchmarkGC times full collections and reports the pause and the number of
+// heap objects the collector has to scan.
+func benchmarkGC(b *testing.B) {
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	b.StopTimer()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+	b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+}
@@ -0,0 +1,153 @@
+package ttlmap
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers to a map, so that expiration can
+// be driven by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the subset of time.Timer used by a map.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock based on the time package, used by default.
+type SystemClock struct{}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// Now returns time.Now().
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer returns a Timer wrapping time.NewTimer.
+func (SystemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// AfterFunc returns a Timer wrapping time.AfterFunc.
+func (SystemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+// FakeClock is a Clock whose time only moves when told to. Timers fire
+// synchronously from Advance and Set, in deadline order, with the clock set to
+// their deadline while they run.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	fn       func()
+	c        chan time.Time
+}
+
+// NewFakeClock creates a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a Timer whose channel receives the fake time when it fires.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc creates a Timer that calls f when it fires.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: c, fn: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the given time, firing the timers that are due. The
+// clock never moves backwards.
+func (c *FakeClock) Set(now time.Time) {
+	for {
+		c.mu.Lock()
+		var next *fakeTimer
+		for t := range c.timers {
+			if !t.deadline.After(now) && (next == nil || t.deadline.Before(next.deadline)) {
+				next = t
+			}
+		}
+		if next == nil {
+			if now.After(c.now) {
+				c.now = now
+			}
+			c.mu.Unlock()
+			return
+		}
+		delete(c.timers, next)
+		if next.deadline.After(c.now) {
+			c.now = next.deadline
+		}
+		fired := c.now
+		c.mu.Unlock()
+		if next.fn != nil {
+			next.fn()
+		} else {
+			select {
+			case next.c <- fired:
+			default:
+			}
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, active := t.clock.timers[t]
+	t.deadline = t.clock.now.Add(d)
+	t.clock.timers[t] = struct{}{}
+	return active
+}
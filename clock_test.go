@@ -0,0 +1,167 @@
+package ttlmap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMapFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	var expired []*testItem
+	opts := &Options{
+		Clock: clock,
+		OnWillExpire: func(key string, item Item) {
+			expired = append(expired, &testItem{key, item, clock.Now()})
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	start := clock.Now()
+	for i := 0; i < 100; i++ {
+		item := NewItem(i, WithTTLFrom(clock, time.Duration(100-i)*time.Second))
+		if err := m.Set(fmt.Sprintf("%d", i), item, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(50 * time.Second)
+	if m.Len() != 51 || len(expired) != 49 {
+		t.Fatalf("Invalid length=%d expired=%d", m.Len(), len(expired))
+	}
+	if err := m.Set("late", NewItem("late", WithTTLFrom(clock, time.Second)), nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	if _, err := m.Get("late"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := m.Get("late"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if m.Len() != 0 || len(expired) != 101 {
+		t.Fatalf("Invalid length=%d expired=%d", m.Len(), len(expired))
+	}
+	for i, eitem := range expired[:100] {
+		if eitem.key == "late" {
+			continue
+		}
+		ttl := time.Duration(eitem.item.Value().(int)-100) * -time.Second
+		if diff := eitem.timestamp.Sub(start.Add(ttl)); diff < 0 || diff > time.Microsecond {
+			t.Fatalf("Wrong expiration time for %d: %v", i, diff)
+		}
+	}
+}
+
+func TestMapFakeClockStrict(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	m := New(&Options{Clock: clock, StrictExpiration: true})
+	defer m.Drain()
+	if err := m.Set("foo", NewItem("bar", WithTTLFrom(clock, time.Second)), nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.mu.Lock()
+	clock.now = clock.now.Add(2 * time.Second)
+	clock.mu.Unlock()
+	if _, err := m.Get("foo"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	timer := clock.NewTimer(time.Second)
+	var fired []time.Time
+	clock.AfterFunc(2*time.Second, func() {
+		fired = append(fired, clock.Now())
+	})
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatalf("Not expecting timer to fire")
+	default:
+	}
+	clock.Advance(3 * time.Second)
+	select {
+	case now := <-timer.C():
+		if !now.Equal(time.Unix(1, 0)) {
+			t.Fatalf("Invalid fire time %v", now)
+		}
+	default:
+		t.Fatalf("Expecting timer to fire")
+	}
+	if len(fired) != 1 || !fired[0].Equal(time.Unix(2, 0)) {
+		t.Fatalf("Invalid fired=%v", fired)
+	}
+	if !clock.Now().Equal(time.Unix(3, 500000000)) {
+		t.Fatalf("Invalid now %v", clock.Now())
+	}
+	if timer.Reset(time.Second) || !timer.Stop() || timer.Stop() {
+		t.Fatalf("Invalid timer state")
+	}
+}
+
+// resetCountingClock counts the resets of the timers of a FakeClock.
+type resetCountingClock struct {
+	*FakeClock
+	resets int
+}
+
+func (c *resetCountingClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &resetCountingTimer{c.FakeClock.AfterFunc(d, f), c}
+}
+
+type resetCountingTimer struct {
+	Timer
+	clock *resetCountingClock
+}
+
+func (t *resetCountingTimer) Reset(d time.Duration) bool {
+	t.clock.resets++
+	return t.Timer.Reset(d)
+}
+
+func TestMapExpirationTolerance(t *testing.T) {
+	for _, tolerance := range []time.Duration{0, time.Millisecond} {
+		clock := &resetCountingClock{FakeClock: NewFakeClock(time.Unix(0, 0))}
+		var expired int
+		m := New(&Options{
+			Clock:               clock,
+			ExpirationTolerance: tolerance,
+			Stats:               true,
+			OnWillExpire: func(key string, item Item) {
+				expired++
+			},
+		})
+		first := 10*time.Second - 500*time.Microsecond
+		if err := m.Set("first", NewItem("first", WithTTLFrom(clock, first)), nil); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(0)
+		resets := clock.resets
+		// Every item becomes the first to expire, by a microsecond.
+		for i := 1; i <= 100; i++ {
+			ttl := first - time.Duration(i)*time.Microsecond
+			if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, ttl)), nil); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(0)
+		}
+		resets = clock.resets - resets
+		if tolerance == 0 && resets < 100 {
+			t.Fatalf("Expecting a reset per item, got %d", resets)
+		} else if tolerance > 0 && resets != 0 {
+			t.Fatalf("Expecting no reset within tolerance, got %d", resets)
+		}
+		sweeps := m.Stats().Sweeps
+		clock.Advance(10*time.Second + 2*time.Millisecond)
+		if expired != 101 {
+			t.Fatalf("Invalid expired count: %d", expired)
+		}
+		if tolerance > 0 && m.Stats().Sweeps-sweeps != 1 {
+			t.Fatalf("Expecting a single sweep, got %d", m.Stats().Sweeps-sweeps)
+		}
+		m.Drain()
+	}
+}
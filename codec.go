@@ -0,0 +1,198 @@
+package ttlmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"slices"
+	"time"
+)
+
+// Codec encodes and decodes keys or values when a map is persisted.
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// GobCodec is a Codec based on encoding/gob. Concrete types stored in
+// interface values must be registered with gob.Register.
+type GobCodec[T any] struct{}
+
+type gobValue[T any] struct {
+	Value T
+}
+
+// Marshal encodes v with gob.
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobValue[T]{v}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a value encoded by Marshal.
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v gobValue[T]
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v.Value, err
+}
+
+const (
+	recordExpires = 1 << iota
+	recordTagged
+)
+
+const maxRecordSize = 1 << 30
+
+// readChunkSize bounds the memory allocated ahead of the data being read, so
+// that a corrupted length fails on the missing data rather than allocating.
+const readChunkSize = 64 << 10
+
+// record is a persisted item with its key and sliding TTL.
+type record[K comparable, V any] struct {
+	key     K
+	item    TypedItem[V]
+	sliding time.Duration
+	tags    []string
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// codecs encodes the records persisted by a map.
+type codecs[K comparable, V any] struct {
+	key   Codec[K]
+	value Codec[V]
+}
+
+func newCodecs[K comparable, V any](opts *TypedOptions[K, V]) *codecs[K, V] {
+	c := &codecs[K, V]{
+		key:   opts.KeyCodec,
+		value: opts.ValueCodec,
+	}
+	if c.key == nil {
+		c.key = GobCodec[K]{}
+	}
+	if c.value == nil {
+		c.value = GobCodec[V]{}
+	}
+	return c
+}
+
+func (c *codecs[K, V]) appendKey(buf []byte, key K) ([]byte, error) {
+	data, err := c.key.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...), nil
+}
+
+func (c *codecs[K, V]) readKey(r byteReader) (K, error) {
+	data, err := readBytes(r)
+	if err != nil {
+		var zero K
+		return zero, err
+	}
+	return c.key.Unmarshal(data)
+}
+
+func (c *codecs[K, V]) appendRecord(buf []byte, rec *record[K, V]) ([]byte, error) {
+	value, err := c.value.Marshal(rec.item.value)
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	if rec.item.expires {
+		flags |= recordExpires
+	}
+	if len(rec.tags) > 0 {
+		flags |= recordTagged
+	}
+	buf = append(buf, flags)
+	if rec.item.expires {
+		buf = binary.AppendVarint(buf, rec.item.expiration.UnixNano())
+	}
+	buf = binary.AppendUvarint(buf, uint64(rec.sliding))
+	if buf, err = c.appendKey(buf, rec.key); err != nil {
+		return nil, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	buf = append(buf, value...)
+	if len(rec.tags) > 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(rec.tags)))
+		for _, tag := range rec.tags {
+			buf = binary.AppendUvarint(buf, uint64(len(tag)))
+			buf = append(buf, tag...)
+		}
+	}
+	return buf, nil
+}
+
+func (c *codecs[K, V]) readRecord(r byteReader) (record[K, V], error) {
+	var rec record[K, V]
+	flags, err := r.ReadByte()
+	if err != nil {
+		return rec, ErrInvalidSnapshot
+	}
+	if flags&recordExpires != 0 {
+		nsec, err := binary.ReadVarint(r)
+		if err != nil {
+			return rec, ErrInvalidSnapshot
+		}
+		rec.item.expires = true
+		rec.item.expiration = time.Unix(0, nsec)
+	}
+	sliding, err := binary.ReadUvarint(r)
+	if err != nil {
+		return rec, ErrInvalidSnapshot
+	}
+	rec.sliding = time.Duration(sliding)
+	if rec.key, err = c.readKey(r); err != nil {
+		return rec, err
+	}
+	value, err := readBytes(r)
+	if err != nil {
+		return rec, err
+	}
+	if rec.item.value, err = c.value.Unmarshal(value); err != nil {
+		return rec, err
+	}
+	if flags&recordTagged != 0 {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return rec, ErrInvalidSnapshot
+		}
+		// Tags are appended as they are read, so that a corrupted count
+		// fails on the missing data rather than allocating.
+		for ; n > 0; n-- {
+			tag, err := readBytes(r)
+			if err != nil {
+				return rec, err
+			}
+			rec.tags = append(rec.tags, string(tag))
+		}
+	}
+	return rec, nil
+}
+
+func readBytes(r byteReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > maxRecordSize {
+		return nil, ErrInvalidSnapshot
+	}
+	data := make([]byte, 0, min(n, readChunkSize))
+	for uint64(len(data)) < n {
+		start := len(data)
+		chunk := int(min(n-uint64(start), readChunkSize))
+		data = slices.Grow(data, chunk)[:start+chunk]
+		if _, err := io.ReadFull(r, data[start:]); err != nil {
+			return nil, ErrInvalidSnapshot
+		}
+	}
+	return data, nil
+}
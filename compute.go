@@ -0,0 +1,134 @@
+package ttlmap
+
+import "errors"
+
+// ComputeAction tells Compute what to do with the item returned by its
+// function.
+type ComputeAction int
+
+const (
+	// ComputeKeep leaves the map unchanged.
+	ComputeKeep ComputeAction = 0
+	// ComputeSet stores the returned item with the key.
+	ComputeSet ComputeAction = 1
+	// ComputeDelete deletes the key from the map.
+	ComputeDelete ComputeAction = 2
+)
+
+// ErrNotComparable is returned by CompareAndSwap and CompareAndDelete for
+// values that cannot be compared with ==.
+var ErrNotComparable = errors.New("values are not comparable")
+
+// CompareAndSwap replaces the item for the key with new if the current item
+// equals old. Items are equal when they have equal values and expirations.
+// ErrNotComparable will be returned if the values cannot be compared with ==;
+// use CompareAndSwapFunc for them.
+// ErrNotExist will be returned if the key does not exist.
+// ErrTooLarge will be returned if new costs more than Options.MaxCost.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) CompareAndSwap(key K, old, new TypedItem[V]) (bool, error) {
+	return m.compareAndSwap(key, old, new, nil)
+}
+
+// CompareAndSwapFunc is like CompareAndSwap, with values compared by eq.
+func (m *TypedMap[K, V]) CompareAndSwapFunc(key K, old, new TypedItem[V], eq func(a, b V) bool) (bool, error) {
+	return m.compareAndSwap(key, old, new, eq)
+}
+
+func (m *TypedMap[K, V]) compareAndSwap(key K, old, new TypedItem[V], eq func(a, b V) bool) (bool, error) {
+	s := m.shard(key)
+	s.store.Lock()
+	defer s.store.unlock()
+	if s.keeper.drained {
+		return false, ErrDrained
+	}
+	pqi := s.lookup(key)
+	if pqi == nil {
+		return false, ErrNotExist
+	}
+	if equal, err := pqi.item.equal(&old, eq); err != nil || !equal {
+		return false, err
+	}
+	if err := s.update(pqi, &new, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete deletes the item for the key if it equals old, as defined
+// by CompareAndSwap.
+// ErrNotComparable will be returned if the values cannot be compared with ==;
+// use CompareAndDeleteFunc for them.
+// ErrNotExist will be returned if the key does not exist.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) CompareAndDelete(key K, old TypedItem[V]) (bool, error) {
+	return m.compareAndDelete(key, old, nil)
+}
+
+// CompareAndDeleteFunc is like CompareAndDelete, with values compared by eq.
+func (m *TypedMap[K, V]) CompareAndDeleteFunc(key K, old TypedItem[V], eq func(a, b V) bool) (bool, error) {
+	return m.compareAndDelete(key, old, eq)
+}
+
+func (m *TypedMap[K, V]) compareAndDelete(key K, old TypedItem[V], eq func(a, b V) bool) (bool, error) {
+	s := m.shard(key)
+	s.store.Lock()
+	defer s.store.unlock()
+	if s.keeper.drained {
+		return false, ErrDrained
+	}
+	pqi := s.lookup(key)
+	if pqi == nil {
+		return false, ErrNotExist
+	}
+	if equal, err := pqi.item.equal(&old, eq); err != nil || !equal {
+		return false, err
+	}
+	s.delete(pqi)
+	return true, nil
+}
+
+// Compute atomically reads, modifies and writes the item for the key. The
+// function is called with the current item, if any, while holding the lock of
+// the key's shard, so it must not use the map. The returned action decides
+// whether the returned item is stored, the key deleted, or nothing changed.
+// Compute returns the item stored for the key once done, if any.
+// ErrTooLarge will be returned if the item to store costs more than
+// Options.MaxCost.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) Compute(key K, fn func(old TypedItem[V], exists bool) (TypedItem[V], ComputeAction)) (TypedItem[V], error) {
+	var zero, old TypedItem[V]
+	s := m.shard(key)
+	s.store.Lock()
+	defer s.store.unlock()
+	if s.keeper.drained {
+		return zero, ErrDrained
+	}
+	pqi := s.lookup(key)
+	if pqi != nil {
+		old = *pqi.item
+	}
+	item, action := fn(old, pqi != nil)
+	switch action {
+	case ComputeSet:
+		if pqi != nil {
+			if err := s.update(pqi, &item, nil); err != nil {
+				return zero, err
+			}
+			return *pqi.item, nil
+		}
+		if err := s.set(key, &item, nil); err != nil {
+			return zero, err
+		}
+		return item, nil
+	case ComputeDelete:
+		if pqi != nil {
+			s.delete(pqi)
+		}
+		return zero, nil
+	}
+	if pqi != nil {
+		return old, nil
+	}
+	return zero, nil
+}
@@ -0,0 +1,118 @@
+package ttlmap
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMapCompareAndSwap(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	foo := NewItem("hello", WithTTL(1*time.Second))
+	bar := NewItem("world", WithTTL(1*time.Second))
+	if ok, err := m.CompareAndSwap("foo", foo, bar); ok || err != ErrNotExist {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if err := m.Set("foo", foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.CompareAndSwap("foo", bar, foo); ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if ok, err := m.CompareAndSwap("foo", foo, bar); !ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if item, err := m.Get("foo"); err != nil || item != bar {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	if ok, err := m.CompareAndDelete("foo", foo); ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if ok, err := m.CompareAndDelete("foo", bar); !ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("Invalid length")
+	}
+	if ok, err := m.CompareAndDelete("foo", bar); ok || err != ErrNotExist {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+}
+
+func TestMapCompareAndSwapFunc(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	foo := NewItem([]byte("hello"), nil)
+	bar := NewItem([]byte("world"), nil)
+	if err := m.Set("foo", foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.CompareAndSwap("foo", foo, bar); ok || err != ErrNotComparable {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if ok, err := m.CompareAndDelete("foo", foo); ok || err != ErrNotComparable {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	typed := NewTyped[string, []byte](nil)
+	defer typed.Drain()
+	if err := typed.Set("foo", NewTypedItem([]byte("hello"), nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	old := NewTypedItem([]byte("hello"), nil)
+	if ok, err := typed.CompareAndSwap("foo", old, NewTypedItem([]byte("world"), nil)); ok || err != ErrNotComparable {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if ok, err := typed.CompareAndSwapFunc("foo", old, NewTypedItem([]byte("world"), nil), bytes.Equal); !ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if ok, err := typed.CompareAndDeleteFunc("foo", old, bytes.Equal); ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+	if ok, err := typed.CompareAndDeleteFunc("foo", NewTypedItem([]byte("world"), nil), bytes.Equal); !ok || err != nil {
+		t.Fatalf("Invalid ok=%v err=%v", ok, err)
+	}
+}
+
+func TestMapCompute(t *testing.T) {
+	m := NewTyped[string, int](nil)
+	defer m.Drain()
+	incr := func(old TypedItem[int], exists bool) (TypedItem[int], ComputeAction) {
+		return NewTypedItem(old.Value()+1, nil), ComputeSet
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := m.Compute("counter", incr); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if item, err := m.Get("counter"); err != nil || item.Value() != 1000 {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	keep := func(old TypedItem[int], exists bool) (TypedItem[int], ComputeAction) {
+		return NewTypedItem(0, nil), ComputeKeep
+	}
+	if item, err := m.Compute("counter", keep); err != nil || item.Value() != 1000 {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	del := func(old TypedItem[int], exists bool) (TypedItem[int], ComputeAction) {
+		if !exists {
+			t.Fatalf("Expecting exists")
+		}
+		return old, ComputeDelete
+	}
+	if _, err := m.Compute("counter", del); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("counter"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,175 @@
+package ttlmap
+
+import (
+	"bytes"
+	"hash/maphash"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// DispatchOptions configures the asynchronous delivery of OnWillExpire,
+// OnWillEvict and OnWillClear callbacks.
+//
+// Removal events are queued while the map lock is held and handed to a pool of
+// workers once it is released, so callbacks may use the map and a slow
+// callback does not stall other operations. Events for the same key are always
+// delivered by the same worker, so callbacks for one key run in order.
+//
+// The queues are bounded: once a worker's queue is full, the operation that
+// removed an item blocks after releasing the lock until there is room. Later
+// operations on the same shard that queue events, including expirations by
+// the keeper, then wait for it after releasing the lock too, to keep events
+// in order. Operations that queue no events, such as Get, are not held up.
+// Operations called from callbacks never wait: their events are queued past
+// the bound, or handed to the operations they would have waited for.
+type DispatchOptions struct {
+	// Workers is the number of goroutines running callbacks. Defaults to 1.
+	Workers int
+	// QueueSize is the number of events each worker can buffer. Defaults to
+	// 1024.
+	QueueSize int
+}
+
+// dispatcher delivers the events queued by the stores of a map to workers.
+type dispatcher[K comparable, V any] struct {
+	seed   maphash.Seed
+	queues []*dispatchQueue[K, V]
+	size   int
+	// workers holds the goroutine ids of the workers, set before the
+	// dispatcher is used.
+	workers   map[uint64]struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// dispatchQueue is the queue of a worker. It holds up to the queue size
+// events, except for those queued by workers, which never wait for room.
+type dispatchQueue[K comparable, V any] struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	events []dispatchedEvent[K, V]
+	closed bool
+}
+
+// dispatchedEvent is an event along with the callbacks it is delivered to,
+// which may have been replaced by Reset since it was queued.
+type dispatchedEvent[K comparable, V any] struct {
+	ev        TypedEvent[K, V]
+	callbacks *callbacks[K, V]
+}
+
+func newDispatcher[K comparable, V any](opts *TypedOptions[K, V]) *dispatcher[K, V] {
+	workers, size := opts.Dispatch.Workers, opts.Dispatch.QueueSize
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 1 {
+		size = 1024
+	}
+	d := &dispatcher[K, V]{
+		seed:    maphash.MakeSeed(),
+		queues:  make([]*dispatchQueue[K, V], workers),
+		size:    size,
+		workers: make(map[uint64]struct{}, workers),
+	}
+	ids := make(chan uint64)
+	for i := range d.queues {
+		q := &dispatchQueue[K, V]{}
+		q.cond.L = &q.mu
+		d.queues[i] = q
+		d.wg.Add(1)
+		go d.run(q, ids)
+	}
+	for range d.queues {
+		d.workers[<-ids] = struct{}{}
+	}
+	return d
+}
+
+func (d *dispatcher[K, V]) run(q *dispatchQueue[K, V], ids chan<- uint64) {
+	defer d.wg.Done()
+	ids <- goid()
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		de := q.events[0]
+		q.events[0] = dispatchedEvent[K, V]{}
+		q.events = q.events[1:]
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		de.callbacks.call(&de.ev)
+	}
+}
+
+// dispatch queues the removal events, except those at the quiet indices, in
+// increasing order.
+func (d *dispatcher[K, V]) dispatch(events []TypedEvent[K, V], quiet []int, callbacks *callbacks[K, V]) {
+	// worker is 1 if the caller is a worker, -1 if not, 0 until needed.
+	worker := 0
+	for n, ev := range events {
+		if len(quiet) > 0 && quiet[0] == n {
+			quiet = quiet[1:]
+			continue
+		}
+		if !ev.Type.removal() {
+			continue
+		}
+		i := 0
+		if len(d.queues) > 1 {
+			i = int(maphash.Comparable(d.seed, ev.Key) % uint64(len(d.queues)))
+		}
+		q := d.queues[i]
+		q.mu.Lock()
+		if len(q.events) >= d.size && worker == 0 {
+			// A worker waiting for room could wait for itself.
+			worker = -1
+			if d.calling() {
+				worker = 1
+			}
+		}
+		for len(q.events) >= d.size && worker < 0 && !q.closed {
+			q.cond.Wait()
+		}
+		q.events = append(q.events, dispatchedEvent[K, V]{ev, callbacks})
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+// calling reports whether the caller is a worker, running a callback.
+func (d *dispatcher[K, V]) calling() bool {
+	_, ok := d.workers[goid()]
+	return ok
+}
+
+// close waits for the queued events to be delivered and stops the workers.
+func (d *dispatcher[K, V]) close() {
+	d.closeOnce.Do(func() {
+		for _, q := range d.queues {
+			q.mu.Lock()
+			q.closed = true
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		}
+		d.wg.Wait()
+	})
+}
+
+// goid returns the id of the calling goroutine, parsed from the first line of
+// its stack trace, "goroutine <id> [...". It is only used on slow paths.
+func goid() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
@@ -0,0 +1,194 @@
+package ttlmap
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMapDispatchReentrant(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	var m *Map
+	var mu sync.Mutex
+	refreshed := 0
+	done := make(chan struct{})
+	opts := &Options{
+		Clock:    clock,
+		Dispatch: &DispatchOptions{Workers: 4},
+		OnWillExpire: func(key string, item Item) {
+			if err := m.Set(key, NewItem("refreshed", nil), nil); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			refreshed++
+			if refreshed == 10 {
+				close(done)
+			}
+			mu.Unlock()
+		},
+	}
+	m = New(opts)
+	defer m.Drain()
+	for i := 0; i < 10; i++ {
+		item := NewItem("value", WithTTLFrom(clock, time.Second))
+		if err := m.Set(fmt.Sprintf("%d", i), item, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(2 * time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expecting callbacks")
+	}
+	for i := 0; i < 10; i++ {
+		if item, err := m.Get(fmt.Sprintf("%d", i)); err != nil || item.Value() != "refreshed" {
+			t.Fatalf("Invalid item=%v err=%v", item, err)
+		}
+	}
+}
+
+func TestMapDispatchOrder(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]string)
+	record := func(kind string) func(key string, item Item) {
+		return func(key string, item Item) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			events[key] = append(events[key], fmt.Sprintf("%s:%v", kind, item.Value()))
+			mu.Unlock()
+		}
+	}
+	opts := &Options{
+		Shards:           4,
+		StrictExpiration: true,
+		Dispatch:         &DispatchOptions{Workers: 3, QueueSize: 2},
+		OnWillExpire:     record("expire"),
+		OnWillEvict:      record("evict"),
+	}
+	m := New(opts)
+	expired := WithExpiration(time.Now().Add(-time.Second))
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("%d", i%3)
+		if err := m.Set(key, NewItem(i, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+		if i == 9 {
+			if err := m.Set(key, NewItem(i+1, expired), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if m.Len() != 2 {
+		t.Fatalf("Invalid length")
+	}
+	m.Drain()
+	expected := map[string]string{
+		"0": "[evict:0 evict:3 evict:6 evict:9 expire:10 evict:10]",
+		"1": "[evict:1 evict:4 evict:7]",
+		"2": "[evict:2 evict:5 evict:8]",
+	}
+	for key, want := range expected {
+		if got := fmt.Sprint(events[key]); got != want {
+			t.Fatalf("Invalid events for %s: %s", key, got)
+		}
+	}
+}
+
+func TestMapDispatchFullQueue(t *testing.T) {
+	release := make(chan struct{})
+	m := New(&Options{
+		Dispatch: &DispatchOptions{Workers: 1, QueueSize: 1},
+		OnWillEvict: func(key string, item Item) {
+			<-release
+		},
+	})
+	defer m.Drain()
+	defer close(release)
+	if err := m.Set("other", NewItem("other", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Each replacing set evicts the previous item, filling the queue while
+	// the callback is stuck.
+	for i := 0; i < 6; i++ {
+		go m.Set("foo", NewItem(i, nil), nil)
+	}
+	time.Sleep(10 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := m.Get("other"); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Get blocked by a full dispatch queue")
+	}
+}
+
+func TestMapDispatchReentrantFullQueue(t *testing.T) {
+	var m *Map
+	var mu sync.Mutex
+	resets := 0
+	done := make(chan struct{})
+	m = New(&Options{
+		Shards:   2,
+		Dispatch: &DispatchOptions{Workers: 1, QueueSize: 1},
+		OnWillEvict: func(key string, item Item) {
+			time.Sleep(time.Millisecond)
+			if key != "foo" {
+				return
+			}
+			mu.Lock()
+			resets++
+			n := resets
+			mu.Unlock()
+			switch {
+			case n < 20:
+				// Replacing the existing key evicts it again.
+				if err := m.Set("foo", NewItem(n, nil), nil); err != nil {
+					t.Error(err)
+				}
+			case n == 20:
+				close(done)
+			}
+		},
+	})
+	if err := m.Set("foo", NewItem(0, nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := m.Set(fmt.Sprintf("%d", i), NewItem(j, nil), nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	if err := m.Set("foo", NewItem(0, nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Callback deadlocked with a full queue")
+	}
+	wg.Wait()
+	drained := make(chan struct{})
+	go func() {
+		m.Drain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain deadlocked")
+	}
+}
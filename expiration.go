@@ -0,0 +1,90 @@
+package ttlmap
+
+import (
+	"iter"
+	"slices"
+	"time"
+)
+
+// NextExpiration returns the key and expiration of the item expiring first,
+// which may already be expired but not yet collected. ok is false if no item
+// expires.
+func (m *TypedMap[K, V]) NextExpiration() (key K, expiration time.Time, ok bool) {
+	for _, s := range m.shards {
+		s.store.RLock()
+		if pqi := s.store.sched.peek(); pqi != nil && pqi.item.expires {
+			if !ok || pqi.item.expiration.Before(expiration) {
+				key, expiration, ok = pqi.key, pqi.item.expiration, true
+			}
+		}
+		s.store.RUnlock()
+	}
+	return key, expiration, ok
+}
+
+// ExpiringBefore returns an iterator over the items expiring before t, in
+// order of expiration. Each shard is copied under its read lock when the
+// iteration starts, so the loop body may safely use the map.
+// Expired items are treated as in Snapshot.
+func (m *TypedMap[K, V]) ExpiringBefore(t time.Time) iter.Seq2[K, TypedItem[V]] {
+	return func(yield func(K, TypedItem[V]) bool) {
+		type entry struct {
+			key  K
+			item TypedItem[V]
+		}
+		var entries []entry
+		for _, s := range m.shards {
+			s.store.RLock()
+			s.store.sched.before(t, func(pqi *pqitem[K, V]) {
+				if !s.store.strict || !s.store.expired(pqi.item) {
+					entries = append(entries, entry{pqi.key, *pqi.item})
+				}
+			})
+			s.store.RUnlock()
+		}
+		slices.SortFunc(entries, func(a, b entry) int {
+			return a.item.expiration.Compare(b.item.expiration)
+		})
+		for _, e := range entries {
+			if !yield(e.key, e.item) {
+				return
+			}
+		}
+	}
+}
+
+// ExpiryBacklog returns the number of expired items not yet collected in the
+// background, and how long ago the oldest of them expired. With
+// Options.Sweep, a growing backlog means that the batches cannot keep up.
+func (m *TypedMap[K, V]) ExpiryBacklog() (n int, lag time.Duration) {
+	for _, s := range m.shards {
+		s.store.RLock()
+		now := s.store.now()
+		s.store.sched.before(now, func(pqi *pqitem[K, V]) {
+			n++
+			lag = max(lag, now.Sub(pqi.item.expiration))
+		})
+		s.store.RUnlock()
+	}
+	return n, lag
+}
+
+// PopExpired removes up to max expired items from the map and returns them,
+// so the caller can process them instead of OnWillExpire. If max is zero or
+// negative, every expired item is removed. The callbacks are not called for
+// popped items, but they are counted, logged and sent to watchers as items
+// expired in the background are.
+func (m *TypedMap[K, V]) PopExpired(max int) map[K]TypedItem[V] {
+	items := make(map[K]TypedItem[V])
+	for _, s := range m.shards {
+		if max > 0 && len(items) >= max {
+			break
+		}
+		s.store.Lock()
+		if !s.keeper.drained {
+			s.popExpired(max, items)
+		}
+		s.store.unlock()
+	}
+	return items
+}
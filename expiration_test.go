@@ -0,0 +1,168 @@
+package ttlmap
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapNextExpiration(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := New(&Options{Clock: clock, Shards: 4})
+	defer m.Drain()
+	if _, _, ok := m.NextExpiration(); ok {
+		t.Fatalf("Expecting no expiration")
+	}
+	if err := m.Set("never", NewItem("never", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := m.NextExpiration(); ok {
+		t.Fatalf("Expecting no expiration")
+	}
+	for i := 5; i > 0; i-- {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, time.Duration(i)*time.Minute)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, expiration, ok := m.NextExpiration()
+	if !ok || key != "1" || !expiration.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("Invalid key=%s expiration=%v ok=%v", key, expiration, ok)
+	}
+}
+
+func TestMapExpiringBefore(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := New(&Options{Clock: clock, Shards: 4})
+	defer m.Drain()
+	if err := m.Set("never", NewItem("never", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i > 0; i-- {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, time.Duration(i)*time.Minute)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	for key, item := range m.ExpiringBefore(clock.Now().Add(4 * time.Minute)) {
+		if item.Value() != len(keys)+1 {
+			t.Fatalf("Invalid item %v for %s", item, key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) != 3 || keys[0] != "1" || keys[2] != "3" {
+		t.Fatalf("Invalid keys: %v", keys)
+	}
+}
+
+func TestMapPopExpired(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var callbacks atomic.Int32
+	m := New(&Options{
+		Clock:    clock,
+		Shards:   2,
+		Stats:    true,
+		Dispatch: &DispatchOptions{},
+		OnWillExpire: func(key string, item Item) {
+			callbacks.Add(1)
+		},
+		OnWillEvict: func(key string, item Item) {
+			callbacks.Add(1)
+		},
+	})
+	defer m.Drain()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := m.Watch(ctx, nil)
+	// Fake timers only fire when the clock moves, so the items are not
+	// collected in the background.
+	for i := 0; i < 5; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, -time.Minute)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("live", NewItem("live", WithTTLFrom(clock, time.Minute)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if items := m.PopExpired(2); len(items) != 2 {
+		t.Fatalf("Invalid items: %v", items)
+	}
+	items := m.PopExpired(0)
+	if len(items) != 3 {
+		t.Fatalf("Invalid items: %v", items)
+	}
+	for key, item := range items {
+		if fmt.Sprintf("%d", item.Value()) != key {
+			t.Fatalf("Invalid item %v for %s", item, key)
+		}
+	}
+	if n := m.Len(); n != 1 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	if stats := m.Stats(); stats.Expirations != 5 {
+		t.Fatalf("Invalid stats: %+v", stats)
+	}
+	m.Drain()
+	if n := callbacks.Load(); n != 1 {
+		t.Fatalf("Expecting only the drain callback, got %d", n)
+	}
+	// Watchers see popped items expire, as if collected in the background.
+	counts := make(map[EventType]int)
+	for ev := range w.Events() {
+		counts[ev.Type]++
+	}
+	if counts[EventExpire] != 5 || counts[EventEvict] != 6 || counts[EventDelete] != 0 {
+		t.Fatalf("Invalid events: %v", counts)
+	}
+}
+
+func TestMapSweepBatches(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var expired int
+	m := New(&Options{
+		Clock: clock,
+		Sweep: &SweepOptions{BatchSize: 100},
+		Stats: true,
+		OnWillExpire: func(key string, item Item) {
+			expired++
+		},
+	})
+	defer m.Drain()
+	for i := 0; i < 1000; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, time.Minute)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sweeps := m.Stats().Sweeps
+	clock.Advance(2 * time.Minute)
+	if expired != 1000 {
+		t.Fatalf("Invalid expired count: %d", expired)
+	}
+	if n := m.Stats().Sweeps - sweeps; n < 10 {
+		t.Fatalf("Expecting at least 10 sweeps, got %d", n)
+	}
+}
+
+func TestMapExpiryBacklog(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := New(&Options{Clock: clock, Shards: 2})
+	defer m.Drain()
+	// Fake timers only fire when the clock moves, so the items are not
+	// collected in the background.
+	for i := 1; i <= 5; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, -time.Duration(i)*time.Second)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("live", NewItem("live", WithTTLFrom(clock, time.Minute)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, lag := m.ExpiryBacklog(); n != 5 || lag != 5*time.Second {
+		t.Fatalf("Invalid backlog n=%d lag=%v", n, lag)
+	}
+	clock.Advance(time.Second)
+	if n, lag := m.ExpiryBacklog(); n != 0 || lag != 0 {
+		t.Fatalf("Invalid backlog n=%d lag=%v", n, lag)
+	}
+}
@@ -2,23 +2,35 @@ package ttlmap
 
 import (
 	"math"
+	"math/rand/v2"
+	"reflect"
 	"time"
 )
 
-// Item represents an item with an associated value and optional expiration.
-type Item struct {
-	value      interface{}
+// TypedItem represents an item with an associated value of type V and
+// optional expiration.
+type TypedItem[V any] struct {
+	value      V
 	expiration time.Time
 	expires    bool
 }
 
+// Item is the TypedItem stored in a Map.
+type Item = TypedItem[interface{}]
+
 // NewItem creates an item with the specified value and optional expiration.
 func NewItem(value interface{}, expiration *time.Time) Item {
+	return NewTypedItem(value, expiration)
+}
+
+// NewTypedItem creates a typed item with the specified value and optional
+// expiration.
+func NewTypedItem[V any](value V, expiration *time.Time) TypedItem[V] {
 	var expiration2 time.Time
 	if expiration != nil {
 		expiration2 = *expiration
 	}
-	return Item{
+	return TypedItem[V]{
 		value:      value,
 		expiration: expiration2,
 		expires:    (expiration != nil),
@@ -26,36 +38,63 @@ func NewItem(value interface{}, expiration *time.Time) Item {
 }
 
 // Value returns the value stored in the item.
-func (item *Item) Value() interface{} {
+func (item *TypedItem[V]) Value() V {
 	return item.value
 }
 
 // Expiration returns the item's expiration time.
-func (item *Item) Expiration() time.Time {
+func (item *TypedItem[V]) Expiration() time.Time {
 	return item.expiration
 }
 
 // TTL returns the remaining duration until expiration (negative if expired).
-func (item *Item) TTL() time.Duration {
+func (item *TypedItem[V]) TTL() time.Duration {
+	return item.TTLAt(time.Now())
+}
+
+// TTLAt returns the remaining duration from now until expiration.
+func (item *TypedItem[V]) TTLAt(now time.Time) time.Duration {
 	if item.expires {
-		return item.expiration.Sub(time.Now())
+		return item.expiration.Sub(now)
 	}
 	return time.Duration(math.MaxInt64)
 }
 
 // Expired checks whether the item is already expired.
-func (item *Item) Expired() bool {
+func (item *TypedItem[V]) Expired() bool {
+	return item.ExpiredAt(time.Now())
+}
+
+// ExpiredAt checks whether the item is expired at the given time.
+func (item *TypedItem[V]) ExpiredAt(now time.Time) bool {
 	if item.expires {
-		return item.expiration.Before(time.Now())
+		return item.expiration.Before(now)
 	}
 	return false
 }
 
 // Expires checks whether the item has an expiration time set.
-func (item *Item) Expires() bool {
+func (item *TypedItem[V]) Expires() bool {
 	return item.expires
 }
 
+// equal reports whether the items have the same expiration and values equal
+// according to eq, or == if eq is nil. ErrNotComparable is returned instead
+// of panicking if == cannot compare the values.
+func (item *TypedItem[V]) equal(other *TypedItem[V], eq func(a, b V) bool) (bool, error) {
+	if eq == nil {
+		if !reflect.ValueOf(&item.value).Elem().Comparable() || !reflect.ValueOf(&other.value).Elem().Comparable() {
+			return false, ErrNotComparable
+		}
+		eq = func(a, b V) bool {
+			return interface{}(a) == interface{}(b)
+		}
+	}
+	return item.expires == other.expires &&
+		item.expiration.Equal(other.expiration) &&
+		eq(item.value, other.value), nil
+}
+
 // WithExpiration creates an expiration time.
 func WithExpiration(expiration time.Time) *time.Time {
 	return &expiration
@@ -66,3 +105,30 @@ func WithTTL(duration time.Duration) *time.Time {
 	expiration := time.Now().Add(duration)
 	return &expiration
 }
+
+// WithTTLJitter creates an expiration time from a specified TTL, extended by
+// a random duration in [0, jitter) so that items set together do not all
+// expire at once.
+func WithTTLJitter(duration, jitter time.Duration) *time.Time {
+	return WithTTL(duration + randDuration(jitter))
+}
+
+// WithTTLJitterFrom is like WithTTLJitter, relative to the current time of the
+// given clock.
+func WithTTLJitterFrom(clock Clock, duration, jitter time.Duration) *time.Time {
+	return WithTTLFrom(clock, duration+randDuration(jitter))
+}
+
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(max)))
+}
+
+// WithTTLFrom creates an expiration time from a specified TTL, relative to the
+// current time of the given clock.
+func WithTTLFrom(clock Clock, duration time.Duration) *time.Time {
+	expiration := clock.Now().Add(duration)
+	return &expiration
+}
@@ -74,3 +74,22 @@ func TestNewItemWithoutExpiration(t *testing.T) {
 		t.Fatalf("Not expecting expiration")
 	}
 }
+
+func TestNewItemWithTTLJitter(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	expirations := make(map[time.Time]bool)
+	for i := 0; i < 100; i++ {
+		item := NewItem("foo", WithTTLJitterFrom(clock, time.Minute, 10*time.Second))
+		if ttl := item.TTLAt(clock.Now()); ttl < time.Minute || ttl >= time.Minute+10*time.Second {
+			t.Fatalf("Invalid TTL: %v", ttl)
+		}
+		expirations[item.Expiration()] = true
+	}
+	if len(expirations) < 2 {
+		t.Fatalf("Expecting jitter")
+	}
+	item := NewItem("foo", WithTTLJitterFrom(clock, time.Minute, 0))
+	if ttl := item.TTLAt(clock.Now()); ttl != time.Minute {
+		t.Fatalf("Invalid TTL: %v", ttl)
+	}
+}
@@ -0,0 +1,68 @@
+package ttlmap
+
+import "iter"
+
+// Snapshot returns a point-in-time copy of the items in the map. Each shard is
+// copied under its read lock, so the copy is consistent per shard.
+//
+// Items that are expired but not yet collected in the background are
+// included, unless Options.StrictExpiration is set.
+func (m *TypedMap[K, V]) Snapshot() map[K]TypedItem[V] {
+	snapshot := make(map[K]TypedItem[V])
+	for _, s := range m.shards {
+		s.store.RLock()
+		for key, pqi := range s.store.kv {
+			if s.store.strict && s.store.expired(pqi.item) {
+				continue
+			}
+			snapshot[key] = *pqi.item
+		}
+		s.store.RUnlock()
+	}
+	return snapshot
+}
+
+// Keys returns the keys in the map, in no particular order.
+// Expired items are treated as in Snapshot.
+func (m *TypedMap[K, V]) Keys() []K {
+	var keys []K
+	m.Range(func(key K, item TypedItem[V]) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Range calls fn for each item in the map, in no particular order, until fn
+// returns false. Each shard is copied under its read lock before fn is called
+// for its items, so fn may safely use the map.
+// Expired items are treated as in Snapshot.
+func (m *TypedMap[K, V]) Range(fn func(key K, item TypedItem[V]) bool) {
+	var keys []K
+	var items []TypedItem[V]
+	for _, s := range m.shards {
+		keys, items = keys[:0], items[:0]
+		s.store.RLock()
+		for key, pqi := range s.store.kv {
+			if s.store.strict && s.store.expired(pqi.item) {
+				continue
+			}
+			keys = append(keys, key)
+			items = append(items, *pqi.item)
+		}
+		s.store.RUnlock()
+		for i, key := range keys {
+			if !fn(key, items[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Items returns an iterator over the items in the map, with the same
+// semantics as Range.
+func (m *TypedMap[K, V]) Items() iter.Seq2[K, TypedItem[V]] {
+	return func(yield func(K, TypedItem[V]) bool) {
+		m.Range(yield)
+	}
+}
@@ -0,0 +1,361 @@
+package ttlmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls how often the mutation log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncEverySecond flushes the log once per second if it was written.
+	SyncEverySecond SyncPolicy = 0
+	// SyncAlways flushes the log after every mutation. Entries are written
+	// with the lock of the item's shard held, so every mutation then waits
+	// for the disk while holding it.
+	SyncAlways SyncPolicy = 1
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = 2
+)
+
+// LogOptions configures an append-only log recording every mutation of a map.
+type LogOptions struct {
+	// Path of the log file. It is created if it does not exist.
+	Path string
+	// Sync is the policy for flushing the log to stable storage.
+	Sync SyncPolicy
+	// CompactThreshold is the size in bytes past which the log is rewritten
+	// from the live items, once it also doubled since the last rewrite.
+	// Defaults to 64 MiB.
+	CompactThreshold int64
+	// OnError is called with errors writing the log, and with errors opening
+	// it when the map is created with New, which panics on them if OnError is
+	// nil.
+	OnError func(err error)
+}
+
+const (
+	logMagic   = "TTLMLOG"
+	logVersion = 1
+)
+
+const defaultCompactThreshold = 64 << 20
+
+// Log operations.
+const (
+	logSet byte = iota + 1
+	logUpdate
+	logDelete
+	logExpire
+	logEvict
+	logClear
+)
+
+// journal appends the mutations of a map to a log file.
+type journal[K comparable, V any] struct {
+	sync.Mutex
+	opts     LogOptions
+	codecs   *codecs[K, V]
+	file     *os.File
+	size     int64
+	baseSize int64
+	dirty    bool
+	buf      []byte
+	stopChan chan struct{}
+	doneChan chan struct{}
+	stopOnce sync.Once
+}
+
+// OpenTyped creates a new TypedMap with given options, like NewTyped, but
+// returns the error opening or replaying Options.Log instead of reporting it
+// to LogOptions.OnError.
+func OpenTyped[K comparable, V any](opts *TypedOptions[K, V]) (*TypedMap[K, V], error) {
+	m := newTyped(opts)
+	if opts != nil && opts.Log != nil {
+		if err := m.openLog(opts.Log); err != nil {
+			m.Drain()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Open creates a new Map with given options, like OpenTyped.
+func Open(opts *Options) (*Map, error) {
+	return OpenTyped(opts)
+}
+
+// openLog replays the log into the map, rewrites it from the live items and
+// starts recording mutations.
+func (m *TypedMap[K, V]) openLog(opts *LogOptions) error {
+	j := &journal[K, V]{
+		opts:     *opts,
+		codecs:   m.codecs,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+	if j.opts.CompactThreshold <= 0 {
+		j.opts.CompactThreshold = defaultCompactThreshold
+	}
+	if err := m.replay(opts.Path); err != nil {
+		return err
+	}
+	if err := j.compact(m); err != nil {
+		return err
+	}
+	for _, s := range m.shards {
+		s.store.Lock()
+		s.store.journal = j
+		s.store.Unlock()
+	}
+	m.journal = j
+	go j.run(m)
+	return nil
+}
+
+// replay applies the entries of the log file to the map. A truncated entry at
+// the end of the log, as left by a crash, is ignored.
+func (m *TypedMap[K, V]) replay(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	header := make([]byte, len(logMagic)+1)
+	if _, err := io.ReadFull(br, header); err == io.EOF {
+		return nil
+	} else if err != nil || string(header[:len(logMagic)]) != logMagic {
+		return ErrInvalidSnapshot
+	}
+	if header[len(logMagic)] != logVersion {
+		return ErrUnsupportedSnapshot
+	}
+	records := make(map[K]*record[K, V])
+	var order []K
+	for {
+		op, payload, err := readLogEntry(br)
+		if err != nil {
+			break
+		}
+		r := bytes.NewReader(payload)
+		switch op {
+		case logSet, logUpdate:
+			rec, err := m.codecs.readRecord(r)
+			if err != nil {
+				return err
+			}
+			if records[rec.key] == nil {
+				order = append(order, rec.key)
+			}
+			records[rec.key] = &rec
+		case logDelete, logExpire, logEvict:
+			key, err := m.codecs.readKey(r)
+			if err != nil {
+				return err
+			}
+			delete(records, key)
+		case logClear:
+			clear(records)
+			order = order[:0]
+		default:
+			return ErrInvalidSnapshot
+		}
+	}
+	for _, key := range order {
+		if rec := records[key]; rec != nil {
+			delete(records, key)
+			// Items too large for the map are dropped, as if evicted.
+			if _, err := m.restore(rec, nil); err != nil && err != ErrTooLarge {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readLogEntry(br *bufio.Reader) (byte, []byte, error) {
+	op, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	payload, err := readBytes(br)
+	return op, payload, err
+}
+
+func appendLogEntry(buf []byte, op byte, payload []byte) []byte {
+	buf = append(buf, op)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	return append(buf, payload...)
+}
+
+// append records a mutation of the item. It is called with the lock of the
+// item's shard held, or of every shard for logClear, which has no item.
+func (j *journal[K, V]) append(op byte, pqi *pqitem[K, V]) {
+	var payload []byte
+	var err error
+	switch op {
+	case logSet, logUpdate:
+		rec := pqi.record()
+		payload, err = j.codecs.appendRecord(nil, &rec)
+	case logClear:
+	default:
+		payload, err = j.codecs.appendKey(nil, pqi.key)
+	}
+	if err != nil {
+		j.report(err)
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.file == nil {
+		return
+	}
+	j.buf = appendLogEntry(j.buf[:0], op, payload)
+	n, err := j.file.Write(j.buf)
+	j.size += int64(n)
+	if err != nil {
+		j.report(err)
+		return
+	}
+	if j.opts.Sync == SyncAlways {
+		if err := j.file.Sync(); err != nil {
+			j.report(err)
+		}
+	} else {
+		j.dirty = true
+	}
+}
+
+// run periodically flushes and compacts the log until the map is drained.
+func (j *journal[K, V]) run(m *TypedMap[K, V]) {
+	defer close(j.doneChan)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stopChan:
+			return
+		case <-ticker.C:
+			j.sync()
+			j.Lock()
+			compact := j.size > j.opts.CompactThreshold && j.size > 2*j.baseSize
+			j.Unlock()
+			if compact {
+				if err := j.compact(m); err != nil {
+					j.report(err)
+				}
+			}
+		}
+	}
+}
+
+func (j *journal[K, V]) sync() {
+	j.Lock()
+	defer j.Unlock()
+	if j.file != nil && j.dirty && j.opts.Sync == SyncEverySecond {
+		j.dirty = false
+		if err := j.file.Sync(); err != nil {
+			j.report(err)
+		}
+	}
+}
+
+// compact rewrites the log from the live items of the map. The shards are read
+// locked together so that no mutation is lost between the copy and the swap.
+func (j *journal[K, V]) compact(m *TypedMap[K, V]) error {
+	for _, s := range m.shards {
+		s.store.RLock()
+	}
+	var records []record[K, V]
+	for _, s := range m.shards {
+		for _, pqi := range s.store.kv {
+			records = append(records, pqi.record())
+		}
+	}
+	j.Lock()
+	defer j.Unlock()
+	for _, s := range m.shards {
+		s.store.RUnlock()
+	}
+	tmp := j.opts.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := writeLog(f, j.codecs, records)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.opts.Path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = f
+	j.size = size
+	j.baseSize = size
+	j.dirty = false
+	return nil
+}
+
+func writeLog[K comparable, V any](f *os.File, c *codecs[K, V], records []record[K, V]) (int64, error) {
+	bw := bufio.NewWriter(f)
+	buf := append([]byte(logMagic), logVersion)
+	size := int64(len(buf))
+	if _, err := bw.Write(buf); err != nil {
+		return 0, err
+	}
+	var payload []byte
+	for i := range records {
+		var err error
+		if payload, err = c.appendRecord(payload[:0], &records[i]); err != nil {
+			return 0, err
+		}
+		buf = appendLogEntry(buf[:0], logSet, payload)
+		size += int64(len(buf))
+		if _, err := bw.Write(buf); err != nil {
+			return 0, err
+		}
+	}
+	return size, bw.Flush()
+}
+
+// close flushes and closes the log. Later mutations are not recorded.
+func (j *journal[K, V]) close() {
+	j.stopOnce.Do(func() {
+		close(j.stopChan)
+		<-j.doneChan
+		j.Lock()
+		defer j.Unlock()
+		if j.file != nil {
+			if j.opts.Sync != SyncNever {
+				if err := j.file.Sync(); err != nil {
+					j.report(err)
+				}
+			}
+			j.file.Close()
+			j.file = nil
+		}
+	})
+}
+
+func (j *journal[K, V]) report(err error) {
+	if j.opts.OnError != nil {
+		j.opts.OnError(err)
+	}
+}
@@ -0,0 +1,206 @@
+package ttlmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMapLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.log")
+	opts := &Options{
+		Shards: 2,
+		Log:    &LogOptions{Path: path, Sync: SyncAlways},
+	}
+	m, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo := NewItem("hello", WithTTL(1*time.Hour))
+	if err := m.Set("foo", foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("bar", NewItem("world", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update("bar", NewItem("world2", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("baz", NewItem("gone", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Delete("baz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("qux", NewItem("soon", WithTTL(50*time.Millisecond)), nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := m.Set("soon", NewItem("soon", WithTTL(50*time.Millisecond)), nil); err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+	time.Sleep(100 * time.Millisecond)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{logSet, 100, 1})
+	f.Close()
+
+	m2, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Drain()
+	if m2.Len() != 2 {
+		t.Fatalf("Invalid length %d", m2.Len())
+	}
+	if item, err := m2.Get("foo"); err != nil || item.Value() != "hello" || !item.Expiration().Equal(foo.Expiration()) {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	if item, err := m2.Get("bar"); err != nil || item.Value() != "world2" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
+
+func TestMapLogClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.log")
+	opts := &Options{
+		Shards: 2,
+		Log:    &LogOptions{Path: path, Sync: SyncAlways},
+	}
+	m, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMapSetN(t, m, 10, time.Hour)
+	m.Clear()
+	if err := m.Set("foo", NewItem("bar", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+
+	m2, err := Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Drain()
+	if m2.Len() != 1 {
+		t.Fatalf("Invalid length %d", m2.Len())
+	}
+	if item, err := m2.Get("foo"); err != nil || item.Value() != "bar" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
+
+func TestMapLogCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.log")
+	m, err := Open(&Options{Log: &LogOptions{Path: path, Sync: SyncNever}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Drain()
+	for i := 0; i < 1000; i++ {
+		if err := m.Set("foo", NewItem(i, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.journal.compact(m); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size()/100 {
+		t.Fatalf("Expecting compacted log, got %d bytes from %d", after.Size(), before.Size())
+	}
+	if err := m.Set("bar", NewItem("world", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+	m2 := New(&Options{Log: &LogOptions{Path: path}})
+	defer m2.Drain()
+	if item, err := m2.Get("foo"); err != nil || item.Value() != 999 {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	if _, err := m2.Get("bar"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapLogInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.log")
+	if err := os.WriteFile(path, []byte("garbage!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(&Options{Log: &LogOptions{Path: path}}); err != ErrInvalidSnapshot {
+		t.Fatal(err)
+	}
+	var reported error
+	m := New(&Options{Log: &LogOptions{
+		Path:    path,
+		OnError: func(err error) { reported = err },
+	}})
+	defer m.Drain()
+	if reported != ErrInvalidSnapshot {
+		t.Fatal(reported)
+	}
+}
+
+func TestMapLogPanic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map.log")
+	if err := os.WriteFile(path, []byte("garbage!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expecting New to panic")
+		}
+	}()
+	New(&Options{Log: &LogOptions{Path: path}})
+}
+
+func TestMapLogSliding(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m, err := Open(&Options{
+		Clock:             clock,
+		SlidingExpiration: true,
+		Log:               &LogOptions{Path: filepath.Join(t.TempDir(), "map.log")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Drain()
+	if err := m.Set("foo", NewItem("hello", WithTTLFrom(clock, time.Hour)), nil); err != nil {
+		t.Fatal(err)
+	}
+	logSize := func() int64 {
+		m.journal.Lock()
+		defer m.journal.Unlock()
+		return m.journal.size
+	}
+	size := logSize()
+	for i := 0; i < 100; i++ {
+		clock.Advance(time.Second)
+		if _, err := m.Get("foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := logSize(); n != size {
+		t.Fatalf("Not expecting reads to be logged, log grew by %d bytes", n-size)
+	}
+	clock.Advance(30 * time.Minute)
+	if _, err := m.Get("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if n := logSize(); n == size {
+		t.Fatal("Expecting the refresh to be logged")
+	}
+}
@@ -1,49 +1,45 @@
 package ttlmap
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 /*
 lock
 */
 
-type keeper struct {
-	store        *store
-	updating     bool
+type keeper[K comparable, V any] struct {
+	store    *store[K, V]
+	timer    Timer
+	updating bool
+	// deadline is when the timer fires, zero if it is stopped.
+	deadline     time.Time
 	drained      bool
-	updateChan   chan struct{}
 	drainingChan chan struct{}
 	drainChan    chan struct{}
 	doneChan     chan struct{}
 }
 
-func newKeeper(store *store) *keeper {
-	return &keeper{
+func newKeeper[K comparable, V any](store *store[K, V]) *keeper[K, V] {
+	k := &keeper[K, V]{
 		store:        store,
-		updateChan:   make(chan struct{}, 1),
 		drainingChan: make(chan struct{}),
 		drainChan:    make(chan struct{}, 1),
 		doneChan:     make(chan struct{}),
 	}
+	k.timer = store.clock.AfterFunc(0, k.update)
+	return k
 }
 
-func (k *keeper) run() {
+func (k *keeper[K, V]) run() {
 	defer close(k.doneChan)
-	defer k.drain()
-	timer := time.NewTimer(0)
-	defer timer.Stop()
-	for {
-		select {
-		case <-k.drainingChan:
-			return
-		case <-k.updateChan:
-			k.update(timer, false)
-		case <-timer.C:
-			k.update(timer, true)
-		}
-	}
+	<-k.drainingChan
+	k.timer.Stop()
+	k.drain()
 }
 
-func (k *keeper) signalDrain() {
+func (k *keeper[K, V]) signalDrain() {
 	select {
 	case k.drainChan <- struct{}{}:
 		close(k.drainingChan)
@@ -51,46 +47,84 @@ func (k *keeper) signalDrain() {
 	}
 }
 
-func (k *keeper) signalUpdate() {
+// signalUpdate makes the timer fire as soon as possible to be rescheduled for
+// the new head of the queue. It is called with the store lock held.
+func (k *keeper[K, V]) signalUpdate() {
 	if !k.updating {
 		k.updating = true
-		select {
-		case k.updateChan <- struct{}{}:
-		default:
-		}
+		k.timer.Reset(0)
+	}
+}
+
+// due reports whether the timer has to be rescheduled for the item, which is
+// not the case if it already fires at the end of the tolerance window of the
+// item's expiration.
+func (k *keeper[K, V]) due(pqi *pqitem[K, V]) bool {
+	if k.store.tolerance <= 0 || k.updating || k.deadline.IsZero() || !pqi.item.expires {
+		return true
 	}
+	return k.align(pqi.item.expiration).Before(k.deadline)
 }
 
-func (k *keeper) update(timer *time.Timer, evict bool) {
+// align rounds t up to the end of its tolerance window, so that the items
+// expiring within a window are collected together.
+func (k *keeper[K, V]) align(t time.Time) time.Time {
+	tolerance := k.store.tolerance
+	if tolerance <= 0 {
+		return t
+	}
+	if rem := time.Duration(t.UnixNano() % int64(tolerance)); rem > 0 {
+		t = t.Add(tolerance - rem)
+	} else if rem < 0 {
+		t = t.Add(-rem)
+	}
+	return t
+}
+
+// update evicts the expired items and reschedules the timer for the next
+// expiration. It runs when the timer fires.
+func (k *keeper[K, V]) update() {
 	k.store.Lock()
-	if evict {
-		k.store.evictExpired()
+	defer k.store.unlock()
+	if k.drained {
+		return
+	}
+	start := time.Now()
+	more := k.store.sweep(k.store.sweepSize, k.store.sweepDuration)
+	k.store.stats.countSweep(time.Since(start))
+	if more {
+		// Release the lock before the next batch.
+		k.updating = true
+		k.timer.Reset(0)
+		return
 	}
 	k.updating = false
-	duration, ok := k.nextTTL()
-	k.store.Unlock()
-	if ok {
-		timer.Reset(duration)
-	} else {
-		timer.Stop()
+	k.deadline = time.Time{}
+	if duration, ok := k.nextTTL(); ok {
+		k.timer.Reset(duration)
 	}
 }
 
-func (k *keeper) nextTTL() (time.Duration, bool) {
-	pqi := k.store.pq.peek()
-	if pqi == nil {
+func (k *keeper[K, V]) nextTTL() (time.Duration, bool) {
+	now := k.store.now()
+	duration, ok := k.store.sched.next(now)
+	if !ok {
 		return 0, false
 	}
-	duration := pqi.item.TTL()
-	if duration < 0 {
-		duration = 0
+	if k.store.tolerance > 0 && duration < math.MaxInt64-k.store.tolerance {
+		k.deadline = k.align(now.Add(duration))
+		duration = k.deadline.Sub(now)
+	}
+	// Items are only expired once their expiration is in the past.
+	if duration <= 0 {
+		duration = time.Nanosecond
 	}
 	return duration, true
 }
 
-func (k *keeper) drain() {
+func (k *keeper[K, V]) drain() {
 	k.store.Lock()
 	k.drained = true
 	k.store.drain()
-	k.store.Unlock()
+	k.store.unlock()
 }
@@ -0,0 +1,143 @@
+package ttlmap
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// call is an in-flight or completed loader call shared by concurrent
+// GetOrLoad callers of the same key.
+type call[V any] struct {
+	done chan struct{}
+	item TypedItem[V]
+	err  error
+	// panic is set if load panicked.
+	panic *loadPanic
+	// waiters is the number of callers waiting for the call, and cancel
+	// cancels its context once none is left.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// loadPanic is the value GetOrLoad callers panic with when load panicked,
+// along with the stack of the loading goroutine.
+type loadPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *loadPanic) Error() string {
+	return fmt.Sprintf("ttlmap: load panicked: %v\n\n%s", p.value, p.stack)
+}
+
+func (p *loadPanic) Unwrap() error {
+	err, _ := p.value.(error)
+	return err
+}
+
+// loader deduplicates concurrent loads of the same key.
+type loader[K comparable, V any] struct {
+	sync.Mutex
+	calls map[K]*call[V]
+}
+
+// GetOrSet returns the existing item for the key if present. Otherwise, it
+// sets and returns the given item. The loaded result is true if the item was
+// already present.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) GetOrSet(key K, item TypedItem[V]) (TypedItem[V], bool, error) {
+	var zero TypedItem[V]
+	s := m.shard(key)
+	s.store.Lock()
+	if s.keeper.drained {
+		s.store.unlock()
+		return zero, false, ErrDrained
+	}
+	if pqi := s.lookup(key); pqi != nil {
+		s.read(pqi)
+		item = *pqi.item
+		s.store.unlock()
+		return item, true, nil
+	}
+	err := s.set(key, &item, nil)
+	s.store.unlock()
+	return item, false, err
+}
+
+// GetOrLoad returns the existing item for the key if present. Otherwise, it
+// calls load and sets the returned item in the map.
+//
+// Concurrent misses on the same key share a single load call, which runs with
+// the values of the context of the first caller. Each caller stops waiting
+// when its own context is done, returning ctx.Err(), and the context of load
+// is canceled once every caller stopped waiting. An error returned by load is
+// returned to every waiter and is not cached. If load panics, every waiter
+// panics with an error wrapping the value.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) GetOrLoad(ctx context.Context, key K, load func(ctx context.Context) (TypedItem[V], error)) (TypedItem[V], error) {
+	var zero TypedItem[V]
+	if item, err := m.Get(key); err != ErrNotExist {
+		return item, err
+	}
+	m.loader.Lock()
+	c := m.loader.calls[key]
+	if c == nil {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), cancel: cancel}
+		if m.loader.calls == nil {
+			m.loader.calls = make(map[K]*call[V])
+		}
+		m.loader.calls[key] = c
+		go m.load(loadCtx, key, c, load)
+	}
+	c.waiters++
+	m.loader.Unlock()
+	select {
+	case <-c.done:
+		if c.panic != nil {
+			panic(c.panic)
+		}
+		return c.item, c.err
+	case <-ctx.Done():
+		m.leave(key, c)
+		return zero, ctx.Err()
+	}
+}
+
+// leave removes a waiter from the call, canceling it if none is left. Later
+// callers then start a new call.
+func (m *TypedMap[K, V]) leave(key K, c *call[V]) {
+	m.loader.Lock()
+	defer m.loader.Unlock()
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	c.cancel()
+	if m.loader.calls[key] == c {
+		delete(m.loader.calls, key)
+	}
+}
+
+func (m *TypedMap[K, V]) load(ctx context.Context, key K, c *call[V], load func(ctx context.Context) (TypedItem[V], error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.panic = &loadPanic{value: r, stack: debug.Stack()}
+		}
+		m.loader.Lock()
+		if m.loader.calls[key] == c {
+			delete(m.loader.calls, key)
+		}
+		m.loader.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+	item, err := load(ctx)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.item, _, c.err = m.GetOrSet(key, item)
+}
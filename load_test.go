@@ -0,0 +1,165 @@
+package ttlmap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapGetOrSet(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	foo := NewItem("hello", WithTTL(1*time.Second))
+	if item, loaded, err := m.GetOrSet("foo", foo); err != nil || loaded || item != foo {
+		t.Fatalf("Invalid item=%v loaded=%v err=%v", item, loaded, err)
+	}
+	bar := NewItem("world", WithTTL(1*time.Second))
+	if item, loaded, err := m.GetOrSet("foo", bar); err != nil || !loaded || item != foo {
+		t.Fatalf("Invalid item=%v loaded=%v err=%v", item, loaded, err)
+	}
+	m.Drain()
+	if _, _, err := m.GetOrSet("foo", bar); err != ErrDrained {
+		t.Fatal(err)
+	}
+}
+
+func TestMapGetOrLoad(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	var calls int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (Item, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return NewItem("loaded", nil), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := m.GetOrLoad(context.Background(), "foo", load)
+			if err != nil || item.Value() != "loaded" {
+				t.Errorf("Invalid item=%v err=%v", item, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("Expecting a single load, got %d", calls)
+	}
+	if item, err := m.Get("foo"); err != nil || item.Value() != "loaded" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
+
+func TestMapGetOrLoadError(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	errLoad := errors.New("load failed")
+	load := func(ctx context.Context) (Item, error) {
+		return zeroItem, errLoad
+	}
+	if _, err := m.GetOrLoad(context.Background(), "foo", load); err != errLoad {
+		t.Fatal(err)
+	}
+	load = func(ctx context.Context) (Item, error) {
+		return NewItem("loaded", nil), nil
+	}
+	if item, err := m.GetOrLoad(context.Background(), "foo", load); err != nil || item.Value() != "loaded" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
+
+func TestMapGetOrLoadCancel(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	release := make(chan struct{})
+	defer close(release)
+	load := func(ctx context.Context) (Item, error) {
+		<-release
+		return NewItem("loaded", nil), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.GetOrLoad(ctx, "foo", load); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestMapGetOrLoadCancelAll(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	loadCtx := make(chan context.Context, 1)
+	load := func(ctx context.Context) (Item, error) {
+		loadCtx <- ctx
+		<-ctx.Done()
+		return zeroItem, ctx.Err()
+	}
+	var wg sync.WaitGroup
+	getOrLoad := func() context.CancelFunc {
+		ctx, cancel := context.WithCancel(context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.GetOrLoad(ctx, "foo", load); err != context.Canceled {
+				t.Errorf("Expecting context.Canceled, got %v", err)
+			}
+		}()
+		return cancel
+	}
+	cancel1 := getOrLoad()
+	ctx := <-loadCtx
+	cancel2 := getOrLoad()
+	// Wait for the second caller to join the load.
+	for {
+		m.loader.Lock()
+		waiters := m.loader.calls["foo"].waiters
+		m.loader.Unlock()
+		if waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel1()
+	time.Sleep(10 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("Not expecting the load to be canceled while a caller waits")
+	}
+	cancel2()
+	wg.Wait()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expecting the load to be canceled")
+	}
+}
+
+func TestMapGetOrLoadPanic(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	errLoad := errors.New("load failed")
+	load := func(ctx context.Context) (Item, error) {
+		panic(errLoad)
+	}
+	func() {
+		defer func() {
+			err, ok := recover().(error)
+			if !ok || !errors.Is(err, errLoad) {
+				t.Fatalf("Expecting a panic wrapping errLoad, got %v", err)
+			}
+		}()
+		m.GetOrLoad(context.Background(), "foo", load)
+	}()
+	load = func(ctx context.Context) (Item, error) {
+		return NewItem("loaded", nil), nil
+	}
+	if item, err := m.GetOrLoad(context.Background(), "foo", load); err != nil || item.Value() != "loaded" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
@@ -1,178 +1,389 @@
-// Package ttlmap provides a map-like interface with string keys and expirable
-// items. Keys are currently limited to strings.
+// Package ttlmap provides a map-like interface with expirable items.
+//
+// TypedMap accepts any comparable key type and any value type. Map is the
+// original string-keyed variant storing interface{} values, and remains an
+// alias of TypedMap[string, interface{}].
 package ttlmap
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
 
 // Errors returned Map operations.
 var (
 	ErrNotExist = errors.New("key does not exist")
 	ErrExist    = errors.New("key already exists")
 	ErrDrained  = errors.New("map was drained")
+	ErrTooLarge = errors.New("item too large")
 )
 
-var zeroItem Item
+// TypedMap is the equivalent of a map[K]V but with expirable Items.
+type TypedMap[K comparable, V any] struct {
+	shards       []*shard[K, V]
+	seed         maphash.Seed
+	drainOnce    sync.Once
+	drainingChan chan struct{}
+	loader       loader[K, V]
+	codecs       *codecs[K, V]
+	journal      *journal[K, V]
+	dispatcher   *dispatcher[K, V]
+	hub          hub[K, V]
+	keyString    func(key K) string
+	drainedChan  chan struct{}
+	drains       atomic.Uint64
+}
 
 // Map is the equivalent of a map[string]interface{} but with expirable Items.
-type Map struct {
-	store  *store
-	keeper *keeper
-}
+type Map = TypedMap[string, interface{}]
 
 // New creates a new Map with given options.
 func New(opts *Options) *Map {
+	return NewTyped(opts)
+}
+
+// NewTyped creates a new TypedMap with given options.
+// If Options.Log is set, the log is replayed first. Errors opening the log are
+// reported to LogOptions.OnError and leave the map without a log. NewTyped
+// panics on them if OnError is nil; use OpenTyped to handle them instead.
+func NewTyped[K comparable, V any](opts *TypedOptions[K, V]) *TypedMap[K, V] {
+	m := newTyped(opts)
+	if opts != nil && opts.Log != nil {
+		if err := m.openLog(opts.Log); err != nil {
+			if opts.Log.OnError == nil {
+				m.Drain()
+				panic("ttlmap: opening log: " + err.Error())
+			}
+			opts.Log.OnError(err)
+		}
+	}
+	return m
+}
+
+func newTyped[K comparable, V any](opts *TypedOptions[K, V]) *TypedMap[K, V] {
 	if opts == nil {
-		opts = &Options{}
+		opts = &TypedOptions[K, V]{}
+	}
+	n := opts.shards()
+	clock := opts.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	m := &TypedMap[K, V]{
+		shards:       make([]*shard[K, V], n),
+		seed:         maphash.MakeSeed(),
+		drainingChan: make(chan struct{}),
+		drainedChan:  make(chan struct{}),
+		codecs:       newCodecs(opts),
+		keyString:    keyString[K](),
+	}
+	if opts.Dispatch != nil {
+		m.dispatcher = newDispatcher(opts)
 	}
-	store := newStore(opts)
-	m := &Map{
-		store:  store,
-		keeper: newKeeper(store),
+	for i := range m.shards {
+		shardOpts := opts.perShard(i, n)
+		shardOpts.Clock = clock
+		m.shards[i] = newShard(shardOpts)
+		m.shards[i].store.dispatcher = m.dispatcher
+		m.shards[i].store.hub = &m.hub
+		go m.shards[i].keeper.run()
 	}
-	go m.keeper.run()
 	return m
 }
 
 // Len returns the number of elements in the map.
-func (m *Map) Len() int {
-	m.store.RLock()
-	n := len(m.store.kv)
-	m.store.RUnlock()
+// With Options.StrictExpiration, expired elements are collected first.
+func (m *TypedMap[K, V]) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.store.RLock()
+		if !s.store.strict {
+			n += len(s.store.kv)
+			s.store.RUnlock()
+			continue
+		}
+		s.store.RUnlock()
+		s.store.Lock()
+		if !s.keeper.drained {
+			s.evictExpired()
+		}
+		n += len(s.store.kv)
+		s.store.unlock()
+	}
 	return n
 }
 
+// Cost returns the total cost of the elements in the map, as computed by
+// Options.Cost.
+func (m *TypedMap[K, V]) Cost() int64 {
+	var cost int64
+	for _, s := range m.shards {
+		s.store.RLock()
+		cost += s.store.totalCost
+		s.store.RUnlock()
+	}
+	return cost
+}
+
 // Get returns the item in the map with the given key.
 // ErrNotExist will be returned if the key does not exist.
 // ErrDrained will be returned if the map is already drained.
-func (m *Map) Get(key string) (Item, error) {
-	m.store.RLock()
-	if m.keeper.drained {
-		m.store.RUnlock()
-		return zeroItem, ErrDrained
+func (m *TypedMap[K, V]) Get(key K) (TypedItem[V], error) {
+	var zero TypedItem[V]
+	s := m.shard(key)
+	s.store.RLock()
+	if s.keeper.drained {
+		s.store.RUnlock()
+		return zero, ErrDrained
 	}
-	if pqi := m.store.kv[key]; pqi != nil {
+	if pqi := s.store.kv[key]; pqi != nil {
+		if pqi.sliding > 0 || (s.store.strict && s.store.expired(pqi.item)) {
+			s.store.RUnlock()
+			return s.getLocked(key)
+		}
+		s.store.access(pqi)
 		item := *pqi.item
-		m.store.RUnlock()
+		s.store.RUnlock()
+		s.store.stats.countGet(nil)
 		return item, nil
 	}
-	m.store.RUnlock()
-	return zeroItem, ErrNotExist
+	s.store.RUnlock()
+	s.store.stats.countGet(ErrNotExist)
+	return zero, ErrNotExist
 }
 
 // Set assigns an item with the specified key in the map.
 // ErrExist or ErrNotExist may be returned depending on opts.KeyExist.
+// ErrTooLarge will be returned if the item costs more than Options.MaxCost.
 // ErrDrained will be returned if the map is already drained.
-func (m *Map) Set(key string, item Item, opts *SetOptions) error {
-	m.store.Lock()
-	if m.keeper.drained {
-		m.store.Unlock()
+func (m *TypedMap[K, V]) Set(key K, item TypedItem[V], opts *SetOptions) error {
+	s := m.shard(key)
+	s.store.Lock()
+	if s.keeper.drained {
+		s.store.unlock()
 		return ErrDrained
 	}
-	err := m.set(key, &item, opts)
-	m.store.Unlock()
+	err := s.set(key, &item, opts)
+	s.store.unlock()
 	return err
 }
 
 // Update updates an item with the specified key in the map and returns it.
 // ErrNotExist will be returned if the key does not exist.
+// ErrTooLarge will be returned if the item costs more than Options.MaxCost.
 // ErrDrained will be returned if the map is already drained.
-func (m *Map) Update(key string, item Item, opts *UpdateOptions) (Item, error) {
-	m.store.Lock()
-	if m.keeper.drained {
-		m.store.Unlock()
-		return zeroItem, ErrDrained
-	}
-	if pqi := m.store.kv[key]; pqi != nil {
-		m.update(pqi, &item, opts)
+func (m *TypedMap[K, V]) Update(key K, item TypedItem[V], opts *UpdateOptions) (TypedItem[V], error) {
+	var zero TypedItem[V]
+	s := m.shard(key)
+	s.store.Lock()
+	if s.keeper.drained {
+		s.store.unlock()
+		return zero, ErrDrained
+	}
+	if pqi := s.lookup(key); pqi != nil {
+		if err := s.update(pqi, &item, opts); err != nil {
+			s.store.unlock()
+			return zero, err
+		}
 		item = *pqi.item
-		m.store.Unlock()
+		s.store.unlock()
 		return item, nil
 	}
-	m.store.Unlock()
-	return zeroItem, ErrNotExist
+	s.store.unlock()
+	return zero, ErrNotExist
 }
 
 // Delete deletes the item with the specified key from the map.
 // ErrNotExist will be returned if the key does not exist.
 // ErrDrained will be returned if the map is already drained.
-func (m *Map) Delete(key string) (Item, error) {
-	m.store.Lock()
-	if m.keeper.drained {
-		m.store.Unlock()
-		return zeroItem, ErrDrained
-	}
-	if pqi := m.store.kv[key]; pqi != nil {
-		m.delete(pqi)
+func (m *TypedMap[K, V]) Delete(key K) (TypedItem[V], error) {
+	var zero TypedItem[V]
+	s := m.shard(key)
+	s.store.Lock()
+	if s.keeper.drained {
+		s.store.unlock()
+		return zero, ErrDrained
+	}
+	if pqi := s.lookup(key); pqi != nil {
+		s.delete(pqi)
 		item := *pqi.item
-		m.store.Unlock()
+		s.store.unlock()
 		return item, nil
 	}
-	m.store.Unlock()
-	return zeroItem, ErrNotExist
+	s.store.unlock()
+	return zero, ErrNotExist
 }
 
-// Draining returns the channel that is closed when the map starts draining.
-func (m *Map) Draining() <-chan struct{} {
-	return m.keeper.drainingChan
+// Touch refreshes the expiration of an item with sliding expiration, as a
+// read would, without returning it. Other items are left unchanged.
+// ErrNotExist will be returned if the key does not exist.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) Touch(key K) error {
+	s := m.shard(key)
+	s.store.Lock()
+	defer s.store.unlock()
+	if s.keeper.drained {
+		return ErrDrained
+	}
+	pqi := s.lookup(key)
+	if pqi == nil {
+		return ErrNotExist
+	}
+	s.read(pqi)
+	return nil
 }
 
-// Drain evicts all remaining elements from the map and terminates the usage of
-// this map.
-func (m *Map) Drain() {
-	m.keeper.signalDrain()
-	<-m.keeper.doneChan
+// Clear removes all items from the map, calling Options.OnWillClear for each
+// of them. Unlike Drain, the map remains usable. Clear does nothing once the
+// map is drained.
+func (m *TypedMap[K, V]) Clear() {
+	m.lockAll()
+	m.clear()
+	m.unlockAll()
 }
 
-func (m *Map) set(key string, item *Item, opts *SetOptions) error {
-	if pqi := m.store.kv[key]; pqi != nil {
-		if opts.keyExist() == KeyExistNotYet {
-			return ErrExist
+// Reset clears the map like Clear, then replaces its options with opts. The
+// cleared items are passed to the previous OnWillClear. Shards, Clock, Log,
+// Stats, Dispatch and the codecs cannot be changed and are ignored. Each
+// shard keeps room for at least one item if MaxItems or MaxCost is less than
+// the number of shards. The key index is rebuilt with the new OrderedKeys and
+// KeyCompare.
+func (m *TypedMap[K, V]) Reset(opts *TypedOptions[K, V]) {
+	if opts == nil {
+		opts = &TypedOptions[K, V]{}
+	}
+	m.lockAll()
+	if m.clear() {
+		for i, s := range m.shards {
+			s.store.reset(opts.perShard(i, len(m.shards)))
 		}
-		m.expireOrEvict(pqi)
-	} else if opts.keyExist() == KeyExistAlready {
-		return ErrNotExist
 	}
-	pqi := &pqitem{
-		key:   key,
-		item:  item,
-		index: -1,
+	m.unlockAll()
+}
+
+// clear empties every shard, with all of them locked, and reports whether the
+// map is still usable.
+func (m *TypedMap[K, V]) clear() bool {
+	if m.drained() {
+		return false
 	}
-	m.store.set(pqi)
-	if pqi.index == 0 {
-		m.keeper.signalUpdate()
+	for _, s := range m.shards {
+		s.store.clear()
 	}
-	return nil
+	if m.journal != nil {
+		m.journal.append(logClear, nil)
+	}
+	return true
 }
 
-func (m *Map) update(pqi *pqitem, item *Item, opts *UpdateOptions) {
-	if opts != nil {
-		if opts.KeepValue {
-			item.value = pqi.item.value
+// InvalidateTag deletes every item tagged with tag, as Delete would, with all
+// shards locked at once, and returns the number of deleted items.
+func (m *TypedMap[K, V]) InvalidateTag(tag string) int {
+	m.lockAll()
+	defer m.unlockAll()
+	if m.drained() {
+		return 0
+	}
+	n := 0
+	for _, s := range m.shards {
+		for key := range s.store.tags[tag] {
+			if pqi := s.lookup(key); pqi != nil {
+				s.delete(pqi)
+				n++
+			}
 		}
-		if opts.KeepExpiration {
-			item.expiration = pqi.item.expiration
-			item.expires = pqi.item.expires
+	}
+	return n
+}
+
+// drained reports whether any shard is drained, with all of them locked.
+// Shards are drained one at a time, so a map is partially drained for a while.
+func (m *TypedMap[K, V]) drained() bool {
+	for _, s := range m.shards {
+		if s.keeper.drained {
+			return true
 		}
 	}
-	pqi.item = item
-	m.store.fix(pqi)
-	if pqi.index == 0 {
-		m.keeper.signalUpdate()
+	return false
+}
+
+func (m *TypedMap[K, V]) lockAll() {
+	for _, s := range m.shards {
+		s.store.Lock()
 	}
 }
 
-func (m *Map) expireOrEvict(pqi *pqitem) {
-	if pqi.index == 0 {
-		m.keeper.signalUpdate()
+// unlockAll releases every shard before publishing their events, so that
+// callbacks and watchers do not wait for the other shards.
+func (m *TypedMap[K, V]) unlockAll() {
+	pending := make([]eventBatch[K, V], len(m.shards))
+	for i, s := range m.shards {
+		pending[i] = s.store.release()
 	}
-	if !m.store.tryExpire(pqi) {
-		m.store.evict(pqi)
+	for i, s := range m.shards {
+		s.store.publish(pending[i])
 	}
 }
 
-func (m *Map) delete(pqi *pqitem) {
-	if pqi.index == 0 {
-		m.keeper.signalUpdate()
+// Draining returns the channel that is closed when the map starts draining.
+func (m *TypedMap[K, V]) Draining() <-chan struct{} {
+	return m.drainingChan
+}
+
+// Drain evicts all remaining elements from the map and terminates the usage of
+// this map.
+func (m *TypedMap[K, V]) Drain() {
+	m.DrainContext(context.Background())
+}
+
+// DrainContext is like Drain but stops waiting when the context is done,
+// returning ctx.Err(). Draining then carries on in the background, and the
+// map is unusable either way.
+func (m *TypedMap[K, V]) DrainContext(ctx context.Context) error {
+	m.drainOnce.Do(func() {
+		close(m.drainingChan)
+		m.drains.Add(1)
+		go m.drain()
+	})
+	select {
+	case <-m.drainedChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *TypedMap[K, V]) drain() {
+	defer close(m.drainedChan)
+	if m.journal != nil {
+		m.journal.close()
+	}
+	for _, s := range m.shards {
+		s.keeper.signalDrain()
+	}
+	for _, s := range m.shards {
+		<-s.keeper.doneChan
+	}
+	// Wait for the events queued before draining to be dispatched.
+	for _, s := range m.shards {
+		s.store.flush()
+	}
+	if m.dispatcher != nil {
+		m.dispatcher.close()
+	}
+	m.hub.close()
+}
+
+func (m *TypedMap[K, V]) shard(key K) *shard[K, V] {
+	return m.shards[m.shardIndex(key)]
+}
+
+func (m *TypedMap[K, V]) shardIndex(key K) int {
+	if len(m.shards) == 1 {
+		return 0
 	}
-	m.store.delete(pqi)
+	return int(maphash.Comparable(m.seed, key) % uint64(len(m.shards)))
 }
@@ -1,11 +1,15 @@
 package ttlmap
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+var zeroItem Item
+
 type testItem struct {
 	key       string
 	item      Item
@@ -238,7 +242,7 @@ func TestMapPersistency(t *testing.T) {
 		t.Fatalf("Invalid item=%v err=%v", item, err)
 	}
 	m.Drain()
-	if m.store.pq.Len() != 0 {
+	if m.shards[0].store.sched.len() != 0 {
 		t.Fatalf("Invalid length")
 	}
 	if len(expired) != 1 {
@@ -463,6 +467,33 @@ func TestMapGetAlreadyExpired(t *testing.T) {
 	}
 }
 
+func TestTypedMapSetGet(t *testing.T) {
+	type point struct{ x, y int }
+	var expired []point
+	opts := &TypedOptions[point, int]{
+		OnWillExpire: func(key point, item TypedItem[int]) {
+			expired = append(expired, key)
+		},
+	}
+	m := NewTyped(opts)
+	defer m.Drain()
+	foo := NewTypedItem(42, WithTTL(100*time.Millisecond))
+	if err := m.Set(point{1, 2}, foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := m.Get(point{1, 2}); err != nil || item.Value() != 42 {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	if item, err := m.Get(point{2, 1}); err != ErrNotExist {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	m.Drain()
+	if len(expired) != 1 || expired[0] != (point{1, 2}) {
+		t.Fatalf("Invalid expired=%v", expired)
+	}
+}
+
 func BenchmarkMapGet1(b *testing.B) {
 	b.StopTimer()
 	m := New(nil)
@@ -580,3 +611,322 @@ func BenchmarkMapSetDrainN(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func TestMapStrictExpiration(t *testing.T) {
+	var expired []*testItem
+	opts := &Options{
+		StrictExpiration: true,
+		OnWillExpire: func(key string, item Item) {
+			expired = append(expired, &testItem{key, item, time.Now()})
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	expiration := time.Now().Add(-1 * time.Second)
+	for i := 0; i < 1000; i++ {
+		item := NewItem("bar", WithExpiration(expiration))
+		if err := m.Set("foo", item, nil); err != nil {
+			t.Fatal(err)
+		}
+		if item, err := m.Get("foo"); item != zeroItem || err != ErrNotExist {
+			t.Fatalf("Invalid item=%v err=%v", item, err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		item := NewItem("bar", WithExpiration(expiration))
+		if err := m.Set("foo", item, nil); err != nil {
+			t.Fatal(err)
+		}
+		if item, err := m.Update("foo", item, nil); item != zeroItem || err != ErrNotExist {
+			t.Fatalf("Invalid item=%v err=%v", item, err)
+		}
+		if err := m.Set("foo", item, nil); err != nil {
+			t.Fatal(err)
+		}
+		if m.Len() != 0 {
+			t.Fatalf("Invalid length")
+		}
+	}
+	m.Drain()
+	if len(expired) != 3000 {
+		t.Fatalf("Invalid length %d", len(expired))
+	}
+}
+
+func TestMapIterate(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := New(&Options{Clock: clock, Shards: 4})
+	defer m.Drain()
+	testMapSetN(t, m, 100, time.Hour)
+	// The fake clock does not move, so the expired item is not collected.
+	expired := NewItem("expired", WithExpiration(clock.Now().Add(-1*time.Second)))
+	if err := m.Set("expired", expired, nil); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := m.Snapshot()
+	if len(snapshot) != 101 {
+		t.Fatalf("Invalid length %d", len(snapshot))
+	}
+	if item := snapshot["42"]; item.Value() != "value" {
+		t.Fatalf("Invalid item=%v", item)
+	}
+	if item, ok := snapshot["expired"]; !ok || item.Value() != "expired" {
+		t.Fatalf("Expecting expired item, got %v", item)
+	}
+	seen := make(map[string]bool)
+	for key, item := range m.Items() {
+		if item.Value() == "expired" {
+			continue
+		}
+		seen[key] = true
+		if err := m.Set(key, item, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != 100 {
+		t.Fatalf("Invalid length %d", len(seen))
+	}
+	n := 0
+	m.Range(func(key string, item Item) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("Expecting range to stop")
+	}
+	if keys := m.Keys(); len(keys) != 101 {
+		t.Fatalf("Invalid length %d", len(keys))
+	}
+	clock.Advance(time.Millisecond)
+	if keys := m.Keys(); len(keys) != 100 || len(m.Snapshot()) != 100 {
+		t.Fatalf("Invalid length %d", len(keys))
+	}
+}
+
+func TestMapIterateStrict(t *testing.T) {
+	m := New(&Options{StrictExpiration: true})
+	defer m.Drain()
+	testMapSetN(t, m, 10, time.Hour)
+	expired := NewItem("expired", WithExpiration(time.Now().Add(-1*time.Second)))
+	if err := m.Set("expired", expired, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Snapshot()) != 10 || len(m.Keys()) != 10 {
+		t.Fatalf("Not expecting expired items")
+	}
+}
+
+func TestMapSlidingExpiration(t *testing.T) {
+	var expired []*testItem
+	opts := &Options{
+		SlidingExpiration: true,
+		OnWillExpire: func(key string, item Item) {
+			expired = append(expired, &testItem{key, item, time.Now()})
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	if err := m.Set("foo", NewItem("hello", WithTTL(200*time.Millisecond)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("bar", NewItem("world", WithTTL(200*time.Millisecond)), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := m.Get("foo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Touch("bar"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Len() != 2 {
+		t.Fatalf("Invalid length")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if m.Len() != 0 {
+		t.Fatalf("Invalid length")
+	}
+	if err := m.Touch("foo"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+	m.Drain()
+	if len(expired) != 2 {
+		t.Fatalf("Invalid length")
+	}
+}
+
+func TestMapSlidingExpirationPerItem(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	sliding := &SetOptions{Sliding: true}
+	if err := m.Set("foo", NewItem("hello", WithTTL(200*time.Millisecond)), sliding); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("bar", NewItem("world", WithTTL(200*time.Millisecond)), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		m.Get("foo")
+		m.Get("bar")
+	}
+	if _, err := m.Get("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("bar"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+}
+
+func TestMapDrainContext(t *testing.T) {
+	release := make(chan struct{})
+	opts := &Options{
+		OnWillEvict: func(key string, item Item) {
+			<-release
+		},
+	}
+	m := New(opts)
+	testMapSetN(t, m, 10, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.DrainContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	select {
+	case <-m.Draining():
+	default:
+		t.Fatalf("Expecting draining")
+	}
+	close(release)
+	if err := m.DrainContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("1"); err != ErrDrained {
+		t.Fatal(err)
+	}
+}
+
+func TestMapClear(t *testing.T) {
+	var cleared, evicted atomic.Int32
+	opts := &Options{
+		OnWillEvict: func(key string, item Item) {
+			evicted.Add(1)
+		},
+		OnWillClear: func(key string, item Item) {
+			cleared.Add(1)
+		},
+		Shards: 4,
+	}
+	m := New(opts)
+	defer m.Drain()
+	testMapSetN(t, m, 10, time.Hour)
+	m.Clear()
+	if cleared.Load() != 10 || evicted.Load() != 0 {
+		t.Fatalf("Invalid callbacks: %d cleared, %d evicted", cleared.Load(), evicted.Load())
+	}
+	if n := m.Len(); n != 0 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	if _, err := m.Get("1"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+	testMapSetN(t, m, 5, time.Hour)
+	if n := m.Len(); n != 5 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+}
+
+func TestMapReset(t *testing.T) {
+	var oldCleared, newCleared int
+	opts := &Options{
+		OnWillClear: func(key string, item Item) {
+			oldCleared++
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	testMapSetN(t, m, 10, time.Hour)
+	m.Reset(&Options{
+		MaxItems: 2,
+		OnWillClear: func(key string, item Item) {
+			newCleared++
+		},
+	})
+	if oldCleared != 10 || newCleared != 0 {
+		t.Fatalf("Invalid callbacks: %d old, %d new", oldCleared, newCleared)
+	}
+	for i := 0; i < 5; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem("value", WithTTL(time.Hour)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.Len(); n != 2 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+	m.Clear()
+	if newCleared != 2 {
+		t.Fatalf("Invalid callbacks: %d new", newCleared)
+	}
+}
+
+func TestMapClearDrained(t *testing.T) {
+	m := New(nil)
+	testMapSetN(t, m, 10, time.Hour)
+	m.Drain()
+	m.Clear()
+	m.Reset(nil)
+	if _, err := m.Get("1"); err != ErrDrained {
+		t.Fatal(err)
+	}
+}
+
+func TestMapInvalidateTag(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := New(&Options{Clock: clock, Shards: 4})
+	defer m.Drain()
+	user := &SetOptions{Tags: []string{"user:1"}}
+	both := &SetOptions{Tags: []string{"user:1", "user:2"}}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := m.Set(key, NewItem(key, WithTTLFrom(clock, time.Hour)), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("e", NewItem("e", WithTTLFrom(clock, time.Minute)), both); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("f", NewItem("f", nil), both); err != nil {
+		t.Fatal(err)
+	}
+	// Set without tags drops the tags, Update keeps them.
+	if err := m.Set("a", NewItem("a", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update("b", NewItem("b2", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	if n := m.InvalidateTag("user:1"); n != 3 {
+		t.Fatalf("Invalid count: %d", n)
+	}
+	for _, key := range []string{"b", "d", "f"} {
+		if _, err := m.Get(key); err != ErrNotExist {
+			t.Fatalf("Expecting %s to be invalidated: %v", key, err)
+		}
+	}
+	if _, err := m.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.InvalidateTag("user:2"); n != 0 {
+		t.Fatalf("Invalid count: %d", n)
+	}
+	for _, s := range m.shards {
+		if len(s.store.tags) != 0 {
+			t.Fatalf("Expecting empty tag index: %v", s.store.tags)
+		}
+	}
+}
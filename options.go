@@ -1,12 +1,151 @@
 package ttlmap
 
-// Options for initializing a new Map.
-type Options struct {
+import (
+	"slices"
+	"time"
+)
+
+// TypedOptions for initializing a new TypedMap.
+type TypedOptions[K comparable, V any] struct {
 	InitialCapacity int
-	OnWillExpire    func(key string, item Item)
-	OnWillEvict     func(key string, item Item)
+	OnWillExpire    func(key K, item TypedItem[V])
+	OnWillEvict     func(key K, item TypedItem[V])
+	// OnWillClear is called for every item removed by Clear or Reset.
+	OnWillClear func(key K, item TypedItem[V])
+
+	// MaxItems bounds the number of items in the map. When a new key is set
+	// on a full map, a victim is evicted first. Zero means unbounded.
+	MaxItems int
+	// EvictionPolicy creates the policy that picks the victims when the map is
+	// bounded, such as NewLRUPolicy[string]. It is called once per shard. If
+	// nil, the item closest to its expiration is evicted first.
+	EvictionPolicy func() EvictionPolicy[K]
+	// MaxCost bounds the total cost of the items in the map. Victims are
+	// evicted until the total cost is back under budget. An item costing more
+	// than the whole budget is rejected with ErrTooLarge, without evicting
+	// anything. Zero means unbounded.
+	MaxCost int64
+	// Cost returns the cost of an item, such as its size in bytes. If nil,
+	// every item costs 1.
+	Cost func(key K, item TypedItem[V]) int64
+	// StrictExpiration makes the map treat expired items as absent even if
+	// they were not yet collected in the background. Such items are expired
+	// on the spot when looked up, firing OnWillExpire.
+	StrictExpiration bool
+	// Shards splits the map into independent partitions, each with its own
+	// lock and expiry scheduling, to reduce contention between writers. Keys
+	// are assigned to shards by hash. InitialCapacity, MaxItems and MaxCost
+	// are divided between shards, and each shard enforces its own part, so
+	// evictions may start before the map holds MaxItems items when keys are
+	// unevenly spread, and an item may be too large for its shard's part of
+	// MaxCost. There are no more shards than MaxItems or MaxCost. Zero or one
+	// means a single shard.
+	Shards int
+	// SlidingExpiration makes every read of an item push its expiration
+	// forward by the TTL the item had when it was set or updated.
+	SlidingExpiration bool
+	// KeyCodec and ValueCodec encode keys and values when the map is saved
+	// and loaded. If nil, GobCodec is used.
+	KeyCodec   Codec[K]
+	ValueCodec Codec[V]
+	// Log records every mutation in an append-only log, replayed when the
+	// map is created. Drain closes the log without recording evictions. Reads
+	// refreshing a sliding expiration are only recorded once it moved by half
+	// the TTL, so such an item may expire up to half its TTL early once the
+	// log is replayed.
+	Log *LogOptions
+	// Clock provides the time used for expiration. If nil, SystemClock is
+	// used. Items should then be created with WithTTLFrom.
+	Clock Clock
+	// OrderedKeys keeps the keys in an index, ordered by KeyCompare, for
+	// RangeKeys, RangePrefix and DeletePrefix. The index costs memory and
+	// time on every insertion and removal, so it is off by default.
+	OrderedKeys bool
+	// KeyCompare orders the keys with OrderedKeys, returning a negative
+	// number, zero or a positive number as a is less than, equal to or
+	// greater than b. Keys whose underlying type is string are ordered by
+	// strings.Compare if nil, and must be ordered that way for RangePrefix and
+	// DeletePrefix. Other keys are not indexed without KeyCompare.
+	KeyCompare func(a, b K) int
+	// ExpirationTolerance lets the background expiry be up to this late.
+	// Expirations are rounded up to windows of this duration, so that the
+	// items expiring in the same window are collected together and the timer
+	// is only rescheduled for an item expiring in an earlier window. With
+	// StrictExpiration, expired items are still treated as absent on time.
+	ExpirationTolerance time.Duration
+	// Sweep bounds the work done by the background expiry while holding the
+	// lock of a shard. If nil, every due item is expired at once.
+	Sweep *SweepOptions
+	// TimingWheel schedules expirations with a timing wheel instead of a
+	// heap, for maps holding millions of items.
+	TimingWheel *TimingWheelOptions
+	// Stats enables the counters and timings reported by Stats, at the cost
+	// of atomic operations on every operation, including Get.
+	Stats bool
+	// Dispatch delivers OnWillExpire, OnWillEvict and OnWillClear
+	// asynchronously, after the map lock is released. If nil, they are
+	// called with the lock held.
+	Dispatch *DispatchOptions
+}
+
+// shards returns the number of shards, no more than MaxItems or MaxCost so
+// that every shard can hold an item.
+func (opts *TypedOptions[K, V]) shards() int {
+	n := max(opts.Shards, 1)
+	if opts.MaxItems > 0 {
+		n = min(n, opts.MaxItems)
+	}
+	if opts.MaxCost > 0 && int64(n) > opts.MaxCost {
+		n = int(opts.MaxCost)
+	}
+	return n
+}
+
+// perShard returns a copy of the options for shard i of n, with capacities
+// divided between them. The limits of the shards add up to those of the map.
+func (opts *TypedOptions[K, V]) perShard(i, n int) *TypedOptions[K, V] {
+	shardOpts := *opts
+	shardOpts.InitialCapacity = (opts.InitialCapacity + n - 1) / n
+	shardOpts.MaxItems = int(share(int64(opts.MaxItems), i, n))
+	shardOpts.MaxCost = share(opts.MaxCost, i, n)
+	return &shardOpts
+}
+
+// share returns the part of a limit given to shard i of n. Each shard gets at
+// least 1, as zero would mean unbounded.
+func share(limit int64, i, n int) int64 {
+	if limit <= 0 {
+		return limit
+	}
+	part := limit / int64(n)
+	if int64(i) < limit%int64(n) {
+		part++
+	}
+	return max(part, 1)
 }
 
+// SweepOptions splits the background expiry into batches, releasing the lock
+// of the shard between them so that other operations can proceed. Items
+// expire later when batches fall behind; see ExpiryBacklog.
+type SweepOptions struct {
+	// BatchSize is the maximum number of items expired per batch. Zero means
+	// no limit.
+	BatchSize int
+	// BatchDuration is the maximum time spent expiring items per batch.
+	// Zero means no limit.
+	BatchDuration time.Duration
+}
+
+func (opts *SweepOptions) limits() (int, time.Duration) {
+	if opts == nil {
+		return 0, 0
+	}
+	return opts.BatchSize, opts.BatchDuration
+}
+
+// Options for initializing a new Map.
+type Options = TypedOptions[string, interface{}]
+
 // KeyExistMode represents a restriction on the existence of a key for the
 // operation to succeed.
 type KeyExistMode int
@@ -23,6 +162,12 @@ const (
 // SetOptions for setting items on a Map.
 type SetOptions struct {
 	KeyExist KeyExistMode
+	// Sliding enables sliding expiration for this item, as
+	// Options.SlidingExpiration does for every item.
+	Sliding bool
+	// Tags attaches tags to the item, to delete it with InvalidateTag. They
+	// are kept by Update and replaced by the next Set.
+	Tags []string
 }
 
 func (opts *SetOptions) keyExist() KeyExistMode {
@@ -32,6 +177,17 @@ func (opts *SetOptions) keyExist() KeyExistMode {
 	return opts.KeyExist
 }
 
+func (opts *SetOptions) sliding() bool {
+	return opts != nil && opts.Sliding
+}
+
+func (opts *SetOptions) tags() []string {
+	if opts == nil || len(opts.Tags) == 0 {
+		return nil
+	}
+	return slices.Clone(opts.Tags)
+}
+
 // UpdateOptions for updating items on a Map.
 type UpdateOptions struct {
 	KeepValue      bool
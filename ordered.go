@@ -0,0 +1,226 @@
+package ttlmap
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+// RangeKeys calls fn for each item with a key in [from, to), in key order,
+// until fn returns false. It needs the index kept with Options.OrderedKeys:
+// without it, or for keys that cannot be compared, fn is never called. Keys
+// are compared with Options.KeyCompare, or strings.Compare for keys whose
+// underlying type is string. Each shard is copied under its read lock before
+// fn is called, so fn may safely use the map. Expired items are treated as in
+// Snapshot.
+func (m *TypedMap[K, V]) RangeKeys(from, to K, fn func(key K, item TypedItem[V]) bool) {
+	m.rangeOrdered(from, func(compare func(a, b K) int, key K) bool {
+		return compare(key, to) < 0
+	}, fn)
+}
+
+// RangePrefix calls fn for each item with a key starting with prefix, in key
+// order, until fn returns false. It only matches keys whose underlying type is
+// string, and is otherwise like RangeKeys: without Options.OrderedKeys, fn is
+// never called.
+func (m *TypedMap[K, V]) RangePrefix(prefix string, fn func(key K, item TypedItem[V]) bool) {
+	if m.keyString == nil {
+		return
+	}
+	m.rangeOrdered(stringToKey[K](prefix), func(_ func(a, b K) int, key K) bool {
+		return strings.HasPrefix(m.keyString(key), prefix)
+	}, fn)
+}
+
+// DeletePrefix deletes every item with a key starting with prefix, as Delete
+// would, with all shards locked at once, and returns the number of deleted
+// items. It only matches keys whose underlying type is string. Without
+// Options.OrderedKeys, every key is scanned instead of seeking in the index.
+func (m *TypedMap[K, V]) DeletePrefix(prefix string) int {
+	if m.keyString == nil {
+		return 0
+	}
+	from := stringToKey[K](prefix)
+	m.lockAll()
+	defer m.unlockAll()
+	if m.drained() {
+		return 0
+	}
+	n := 0
+	var keys []K
+	for _, s := range m.shards {
+		keys = keys[:0]
+		if x := s.store.index; x != nil {
+			for node := x.seek(from); node != nil && strings.HasPrefix(m.keyString(node.key), prefix); node = node.next[0] {
+				keys = append(keys, node.key)
+			}
+		} else {
+			for key := range s.store.kv {
+				if strings.HasPrefix(m.keyString(key), prefix) {
+					keys = append(keys, key)
+				}
+			}
+		}
+		for _, key := range keys {
+			if pqi := s.lookup(key); pqi != nil {
+				s.delete(pqi)
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// rangeOrdered copies the items from the first key not less than from while
+// more holds in each shard, then calls fn for them in key order. The keys are
+// compared with the function of the indexes, which Reset may replace.
+func (m *TypedMap[K, V]) rangeOrdered(from K, more func(compare func(a, b K) int, key K) bool, fn func(key K, item TypedItem[V]) bool) {
+	type entry struct {
+		key  K
+		item TypedItem[V]
+	}
+	runs := make([][]entry, len(m.shards))
+	var compare func(a, b K) int
+	for i, s := range m.shards {
+		s.store.RLock()
+		// The index is gone once the shard is drained.
+		if x := s.store.index; x != nil {
+			compare = x.compare
+			for node := x.seek(from); node != nil && more(compare, node.key); node = node.next[0] {
+				pqi := s.store.kv[node.key]
+				if !s.store.strict || !s.store.expired(pqi.item) {
+					runs[i] = append(runs[i], entry{pqi.key, *pqi.item})
+				}
+			}
+		}
+		s.store.RUnlock()
+	}
+	// Merge the sorted runs of the shards.
+	for {
+		next := -1
+		for i, run := range runs {
+			if len(run) > 0 && (next < 0 || compare(run[0].key, runs[next][0].key) < 0) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+		e := runs[next][0]
+		runs[next] = runs[next][1:]
+		if !fn(e.key, e.item) {
+			return
+		}
+	}
+}
+
+// keyCompare returns the function ordering keys with opts.OrderedKeys:
+// opts.KeyCompare, or strings.Compare for keys whose underlying type is
+// string.
+func keyCompare[K comparable, V any](opts *TypedOptions[K, V]) func(a, b K) int {
+	if !opts.OrderedKeys {
+		return nil
+	}
+	if opts.KeyCompare != nil {
+		return opts.KeyCompare
+	}
+	if str := keyString[K](); str != nil {
+		return func(a, b K) int {
+			return strings.Compare(str(a), str(b))
+		}
+	}
+	return nil
+}
+
+// keyString returns the function converting keys whose underlying type is
+// string to a string, or nil for other keys.
+func keyString[K comparable]() func(key K) string {
+	if reflect.TypeFor[K]().Kind() != reflect.String {
+		return nil
+	}
+	return func(key K) string {
+		return *(*string)(unsafe.Pointer(&key))
+	}
+}
+
+// stringToKey converts s to a key whose underlying type is string.
+func stringToKey[K comparable](s string) K {
+	var key K
+	*(*string)(unsafe.Pointer(&key)) = s
+	return key
+}
+
+const maxIndexLevel = 32
+
+// keyIndex is a skip list keeping the keys of a store in order.
+type keyIndex[K comparable] struct {
+	compare func(a, b K) int
+	head    indexNode[K]
+	level   int
+}
+
+type indexNode[K comparable] struct {
+	key  K
+	next []*indexNode[K]
+}
+
+func newKeyIndex[K comparable](compare func(a, b K) int) *keyIndex[K] {
+	return &keyIndex[K]{
+		compare: compare,
+		head:    indexNode[K]{next: make([]*indexNode[K], maxIndexLevel)},
+		level:   1,
+	}
+}
+
+// find returns the last node before key at each level.
+func (x *keyIndex[K]) find(key K, update *[maxIndexLevel]*indexNode[K]) {
+	n := &x.head
+	for i := x.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && x.compare(n.next[i].key, key) < 0 {
+			n = n.next[i]
+		}
+		update[i] = n
+	}
+}
+
+func (x *keyIndex[K]) insert(key K) {
+	var update [maxIndexLevel]*indexNode[K]
+	x.find(key, &update)
+	// Each level holds a quarter of the nodes of the level below.
+	level := 1 + bits.TrailingZeros64(rand.Uint64())/2
+	if level > maxIndexLevel {
+		level = maxIndexLevel
+	}
+	for ; x.level < level; x.level++ {
+		update[x.level] = &x.head
+	}
+	node := &indexNode[K]{key: key, next: make([]*indexNode[K], level)}
+	for i := range node.next {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+func (x *keyIndex[K]) remove(key K) {
+	var update [maxIndexLevel]*indexNode[K]
+	x.find(key, &update)
+	node := update[0].next[0]
+	if node == nil || x.compare(node.key, key) != 0 {
+		return
+	}
+	for i := range node.next {
+		update[i].next[i] = node.next[i]
+	}
+	for x.level > 1 && x.head.next[x.level-1] == nil {
+		x.level--
+	}
+}
+
+// seek returns the first node with a key not less than key.
+func (x *keyIndex[K]) seek(key K) *indexNode[K] {
+	var update [maxIndexLevel]*indexNode[K]
+	x.find(key, &update)
+	return update[0].next[0]
+}
@@ -0,0 +1,251 @@
+package ttlmap
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyIndex(t *testing.T) {
+	x := newKeyIndex(func(a, b int) int { return a - b })
+	keys := make(map[int]bool)
+	for i := 0; i < 10000; i++ {
+		key := rand.Intn(1000)
+		if keys[key] {
+			x.remove(key)
+		} else {
+			x.insert(key)
+		}
+		keys[key] = !keys[key]
+	}
+	var want, got []int
+	for key, ok := range keys {
+		if ok {
+			want = append(want, key)
+		}
+	}
+	slices.Sort(want)
+	for node := x.seek(-1); node != nil; node = node.next[0] {
+		got = append(got, node.key)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", got, want)
+	}
+}
+
+func testMapOrdered(t *testing.T, opts *Options) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	opts.Clock = clock
+	opts.Shards = 4
+	m := New(opts)
+	defer m.Drain()
+	for _, key := range []string{"a/1", "a/2", "a/3", "ab", "b/1", "b/2", "c"} {
+		if err := m.Set(key, NewItem(key, WithTTLFrom(clock, time.Hour)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("a/0", NewItem("a/0", WithTTLFrom(clock, time.Minute)), nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	collect := func(ranger func(fn func(key string, item Item) bool)) []string {
+		var keys []string
+		ranger(func(key string, item Item) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+	keys := collect(func(fn func(key string, item Item) bool) {
+		m.RangePrefix("a/", fn)
+	})
+	if want := []string{"a/1", "a/2", "a/3"}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+	keys = collect(func(fn func(key string, item Item) bool) {
+		m.RangeKeys("a/2", "b/2", fn)
+	})
+	if want := []string{"a/2", "a/3", "ab", "b/1"}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+	if n := m.DeletePrefix("b/"); n != 2 {
+		t.Fatalf("Invalid count: %d", n)
+	}
+	keys = collect(func(fn func(key string, item Item) bool) {
+		m.RangeKeys("", "z", fn)
+	})
+	if want := []string{"a/1", "a/2", "a/3", "ab", "c"}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+	n := 0
+	m.RangeKeys("", "z", func(key string, item Item) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Fatalf("Expecting range to stop, called %d times", n)
+	}
+}
+
+func TestMapOrdered(t *testing.T) {
+	testMapOrdered(t, &Options{OrderedKeys: true})
+}
+
+func TestMapOrderedCompare(t *testing.T) {
+	testMapOrdered(t, &Options{OrderedKeys: true, KeyCompare: strings.Compare})
+}
+
+func TestMapUnordered(t *testing.T) {
+	m := New(&Options{Shards: 4})
+	defer m.Drain()
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		if err := m.Set(key, NewItem(key, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fail := func(key string, item Item) bool {
+		t.Fatalf("Unexpected key %s", key)
+		return false
+	}
+	m.RangeKeys("", "z", fail)
+	m.RangePrefix("a/", fail)
+	if n := m.DeletePrefix("a/"); n != 2 {
+		t.Fatalf("Invalid count: %d", n)
+	}
+	if n := m.Len(); n != 1 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+}
+
+func TestMapOrderedReset(t *testing.T) {
+	m := New(&Options{Shards: 4, OrderedKeys: true})
+	defer m.Drain()
+	m.Reset(&Options{
+		OrderedKeys: true,
+		KeyCompare: func(a, b string) int {
+			return strings.Compare(b, a)
+		},
+	})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := m.Set(key, NewItem(key, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	m.RangeKeys("c", "a", func(key string, item Item) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if want := []string{"c", "b"}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+}
+
+type testPath string
+
+func TestTypedMapRangePrefixNamedString(t *testing.T) {
+	m := NewTyped(&TypedOptions[testPath, int]{Shards: 2, OrderedKeys: true})
+	defer m.Drain()
+	for i, key := range []testPath{"b/2", "a/1", "b/1", "c"} {
+		if err := m.Set(key, NewTypedItem(i, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []testPath
+	m.RangePrefix("b/", func(key testPath, item TypedItem[int]) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if want := []testPath{"b/1", "b/2"}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+	if n := m.DeletePrefix("a/"); n != 1 {
+		t.Fatalf("Invalid count: %d", n)
+	}
+	m.Drain()
+	m.RangePrefix("", func(key testPath, item TypedItem[int]) bool {
+		t.Fatalf("Unexpected key %s", key)
+		return false
+	})
+}
+
+func TestMapOrderedIndexEvict(t *testing.T) {
+	m := New(&Options{MaxItems: 3, OrderedKeys: true})
+	defer m.Drain()
+	for i := 0; i < 5; i++ {
+		if err := m.Set(fmt.Sprintf("k%d", i), NewItem(i, WithTTL(time.Duration(i+1)*time.Hour)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	m.RangePrefix("k", func(key string, item Item) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if want := []string{"k2", "k3", "k4"}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+}
+
+func TestTypedMapRangeKeys(t *testing.T) {
+	m := NewTyped(&TypedOptions[int, string]{
+		OrderedKeys: true,
+		KeyCompare:  func(a, b int) int { return a - b },
+		Shards:      2,
+	})
+	defer m.Drain()
+	for i := 10; i > 0; i-- {
+		if err := m.Set(i, NewTypedItem(fmt.Sprint(i), nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []int
+	m.RangeKeys(3, 6, func(key int, item TypedItem[string]) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if want := []int{3, 4, 5}; !slices.Equal(keys, want) {
+		t.Fatalf("Invalid keys: %v, expecting %v", keys, want)
+	}
+	m.RangePrefix("1", func(key int, item TypedItem[string]) bool {
+		t.Fatalf("Unexpected key %d", key)
+		return false
+	})
+}
+
+// BenchmarkMapRangePrefix ranges over 100 of 100k items through the key
+// index, while BenchmarkMapRangePrefixScan filters every item with Range.
+func BenchmarkMapRangePrefix(b *testing.B) {
+	benchmarkMapRangePrefix(b, func(m *Map, fn func(key string, item Item) bool) {
+		m.RangePrefix("tenant/42/", fn)
+	})
+}
+
+func BenchmarkMapRangePrefixScan(b *testing.B) {
+	benchmarkMapRangePrefix(b, func(m *Map, fn func(key string, item Item) bool) {
+		m.Range(func(key string, item Item) bool {
+			return !strings.HasPrefix(key, "tenant/42/") || fn(key, item)
+		})
+	})
+}
+
+func benchmarkMapRangePrefix(b *testing.B, ranger func(m *Map, fn func(key string, item Item) bool)) {
+	b.StopTimer()
+	m := New(&Options{OrderedKeys: true})
+	for i := 0; i < 100000; i++ {
+		if err := m.Set(fmt.Sprintf("tenant/%d/user/%d", i%1000, i), NewItem(i, nil), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		ranger(m, func(key string, item Item) bool {
+			return true
+		})
+	}
+	b.StopTimer()
+	m.Drain()
+}
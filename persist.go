@@ -0,0 +1,146 @@
+package ttlmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Errors returned when loading a persisted map.
+var (
+	ErrInvalidSnapshot     = errors.New("invalid snapshot")
+	ErrUnsupportedSnapshot = errors.New("unsupported snapshot version")
+)
+
+const (
+	snapshotMagic   = "TTLMAP"
+	snapshotVersion = 1
+)
+
+// LoadOptions for loading items in a map.
+type LoadOptions struct {
+	// FireExpire calls OnWillExpire for items that expired while persisted,
+	// instead of silently dropping them.
+	FireExpire bool
+}
+
+// SaveTo writes a snapshot of the map to w, using Options.KeyCodec and
+// Options.ValueCodec. Each item keeps its absolute expiration.
+//
+// The format starts with a magic string and a version byte, followed by the
+// number of items and one record per item. Numbers are varint-encoded.
+func (m *TypedMap[K, V]) SaveTo(w io.Writer) error {
+	return m.SaveToContext(context.Background(), w)
+}
+
+// SaveToContext is like SaveTo but stops writing when the context is done,
+// returning ctx.Err().
+func (m *TypedMap[K, V]) SaveToContext(ctx context.Context, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	records := m.records()
+	buf := append([]byte(snapshotMagic), snapshotVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(records)))
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	for i := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		buf, err = m.codecs.appendRecord(buf[:0], &records[i])
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// LoadFrom reads a snapshot written by SaveTo from r and sets its items in the
+// map, replacing existing items with the same keys. Items that expired in the
+// meantime are dropped. It returns the number of items set.
+// ErrDrained will be returned if the map is already drained.
+func (m *TypedMap[K, V]) LoadFrom(r io.Reader, opts *LoadOptions) (int, error) {
+	return m.LoadFromContext(context.Background(), r, opts)
+}
+
+// LoadFromContext is like LoadFrom but stops reading when the context is done,
+// returning the number of items set so far and ctx.Err().
+func (m *TypedMap[K, V]) LoadFromContext(ctx context.Context, r io.Reader, opts *LoadOptions) (int, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, ErrInvalidSnapshot
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return 0, ErrInvalidSnapshot
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return 0, ErrUnsupportedSnapshot
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, ErrInvalidSnapshot
+	}
+	n := 0
+	for i := uint64(0); i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		rec, err := m.codecs.readRecord(br)
+		if err != nil {
+			return n, err
+		}
+		ok, err := m.restore(&rec, opts)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// records copies the items of the map with their sliding TTLs and tags.
+func (m *TypedMap[K, V]) records() []record[K, V] {
+	var records []record[K, V]
+	for _, s := range m.shards {
+		s.store.RLock()
+		for _, pqi := range s.store.kv {
+			records = append(records, pqi.record())
+		}
+		s.store.RUnlock()
+	}
+	return records
+}
+
+// restore sets a loaded record in the map, reporting whether it was set.
+func (m *TypedMap[K, V]) restore(rec *record[K, V], opts *LoadOptions) (bool, error) {
+	s := m.shard(rec.key)
+	s.store.Lock()
+	defer s.store.unlock()
+	if s.keeper.drained {
+		return false, ErrDrained
+	}
+	if s.store.expired(&rec.item) {
+		if opts != nil && opts.FireExpire {
+			s.store.notifyExpire(rec.key, rec.item)
+		}
+		return false, nil
+	}
+	item := rec.item
+	if err := s.set(rec.key, &item, &SetOptions{Tags: rec.tags}); err != nil {
+		return false, err
+	}
+	if pqi := s.store.kv[rec.key]; pqi != nil && pqi.sliding != rec.sliding {
+		pqi.sliding = rec.sliding
+		s.store.log(logUpdate, pqi)
+	}
+	return true, nil
+}
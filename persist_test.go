@@ -0,0 +1,146 @@
+package ttlmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMapSaveLoad(t *testing.T) {
+	m := New(&Options{Shards: 2})
+	defer m.Drain()
+	foo := NewItem("hello", WithTTL(1*time.Hour))
+	bar := NewItem(42, nil)
+	baz := NewItem("soon", WithTTL(100*time.Millisecond))
+	for key, item := range map[string]Item{"foo": foo, "bar": bar, "baz": baz} {
+		if err := m.Set(key, item, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("qux", NewItem("sliding", WithTTL(1*time.Hour)), &SetOptions{Sliding: true, Tags: []string{"tag"}}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	var expired []string
+	m2 := New(&Options{
+		OnWillExpire: func(key string, item Item) {
+			expired = append(expired, key)
+		},
+	})
+	defer m2.Drain()
+	n, err := m2.LoadFrom(&buf, &LoadOptions{FireExpire: true})
+	if err != nil || n != 3 {
+		t.Fatalf("Invalid n=%d err=%v", n, err)
+	}
+	if item, err := m2.Get("foo"); err != nil || item.Value() != "hello" || !item.Expiration().Equal(foo.Expiration()) {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	if item, err := m2.Get("bar"); err != nil || item.Value() != 42 || item.Expires() {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	if _, err := m2.Get("baz"); err != ErrNotExist {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0] != "baz" {
+		t.Fatalf("Invalid expired=%v", expired)
+	}
+	pqi := m2.shards[0].store.kv["qux"]
+	if pqi == nil || pqi.sliding < 59*time.Minute {
+		t.Fatalf("Expecting sliding expiration")
+	}
+	if n := m2.InvalidateTag("tag"); n != 1 {
+		t.Fatalf("Expecting tags to be restored, invalidated %d", n)
+	}
+}
+
+func TestMapLoadInvalid(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	if _, err := m.LoadFrom(bytes.NewReader([]byte("garbage")), nil); err != ErrInvalidSnapshot {
+		t.Fatal(err)
+	}
+	data := append([]byte(snapshotMagic), snapshotVersion+1, 0)
+	if _, err := m.LoadFrom(bytes.NewReader(data), nil); err != ErrUnsupportedSnapshot {
+		t.Fatal(err)
+	}
+	data = append([]byte(snapshotMagic), snapshotVersion, 1, 0)
+	if _, err := m.LoadFrom(bytes.NewReader(data), nil); err != ErrInvalidSnapshot {
+		t.Fatal(err)
+	}
+	// A corrupted key length fails without allocating it.
+	data = append([]byte(snapshotMagic), snapshotVersion, 1, 0, 0)
+	data = binary.AppendUvarint(data, maxRecordSize)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := m.LoadFrom(bytes.NewReader(data), nil); err != ErrInvalidSnapshot {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("Invalid allocation of %d bytes", n)
+	}
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (stringCodec) Unmarshal(data []byte) (string, error) {
+	return string(data), nil
+}
+
+func TestTypedMapSaveLoadCodec(t *testing.T) {
+	opts := &TypedOptions[string, string]{
+		KeyCodec:   stringCodec{},
+		ValueCodec: stringCodec{},
+	}
+	m := NewTyped(opts)
+	defer m.Drain()
+	if err := m.Set("foo", NewTypedItem("hello", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("\x03foo\x05hello")) {
+		t.Fatalf("Expecting raw encoding")
+	}
+	m2 := NewTyped(opts)
+	defer m2.Drain()
+	if n, err := m2.LoadFrom(&buf, nil); err != nil || n != 1 {
+		t.Fatalf("Invalid n=%d err=%v", n, err)
+	}
+	if item, err := m2.Get("foo"); err != nil || item.Value() != "hello" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
+
+func TestMapSaveLoadContext(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	testMapSetN(t, m, 10, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	if err := m.SaveToContext(ctx, &buf); err != context.Canceled {
+		t.Fatal(err)
+	}
+	if err := m.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	m2 := New(nil)
+	defer m2.Drain()
+	if n, err := m2.LoadFromContext(ctx, &buf, nil); n != 0 || err != context.Canceled {
+		t.Fatalf("Invalid n=%d err=%v", n, err)
+	}
+}
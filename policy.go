@@ -0,0 +1,199 @@
+package ttlmap
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+)
+
+// EvictionPolicy picks the key to evict when a map bounded by MaxItems is
+// full. The map reports every key it adds, accesses and removes, and asks for
+// a victim whenever room is needed for a new key.
+//
+// Implementations must be safe for concurrent use: Accessed is called from
+// Get while only holding the map's read lock.
+type EvictionPolicy[K comparable] interface {
+	// Added is called after a key is inserted in the map.
+	Added(key K)
+	// Accessed is called after the item for an existing key is read or updated.
+	Accessed(key K)
+	// Removed is called after a key is removed from the map for any reason.
+	Removed(key K)
+	// Victim returns the key that should be evicted next.
+	Victim() (K, bool)
+}
+
+func newPolicy[K comparable](newFunc func() EvictionPolicy[K]) EvictionPolicy[K] {
+	if newFunc == nil {
+		return nil
+	}
+	return newFunc()
+}
+
+type listPolicy[K comparable] struct {
+	sync.Mutex
+	promote  bool
+	elements map[K]*list.Element
+	order    *list.List
+}
+
+// NewLRUPolicy creates an EvictionPolicy that evicts the least recently used
+// key first.
+func NewLRUPolicy[K comparable]() EvictionPolicy[K] {
+	return newListPolicy[K](true)
+}
+
+// NewFIFOPolicy creates an EvictionPolicy that evicts the oldest inserted key
+// first, regardless of accesses.
+func NewFIFOPolicy[K comparable]() EvictionPolicy[K] {
+	return newListPolicy[K](false)
+}
+
+func newListPolicy[K comparable](promote bool) *listPolicy[K] {
+	return &listPolicy[K]{
+		promote:  promote,
+		elements: make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (p *listPolicy[K]) Added(key K) {
+	p.Lock()
+	if e := p.elements[key]; e != nil {
+		p.order.MoveToBack(e)
+	} else {
+		p.elements[key] = p.order.PushBack(key)
+	}
+	p.Unlock()
+}
+
+func (p *listPolicy[K]) Accessed(key K) {
+	if !p.promote {
+		return
+	}
+	p.Lock()
+	if e := p.elements[key]; e != nil {
+		p.order.MoveToBack(e)
+	}
+	p.Unlock()
+}
+
+func (p *listPolicy[K]) Removed(key K) {
+	p.Lock()
+	if e := p.elements[key]; e != nil {
+		p.order.Remove(e)
+		delete(p.elements, key)
+	}
+	p.Unlock()
+}
+
+func (p *listPolicy[K]) Victim() (K, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if e := p.order.Front(); e != nil {
+		return e.Value.(K), true
+	}
+	var zero K
+	return zero, false
+}
+
+type lfuEntry[K comparable] struct {
+	key   K
+	freq  uint64
+	seq   uint64
+	index int
+}
+
+type lfuQueue[K comparable] []*lfuEntry[K]
+
+func (q lfuQueue[K]) Len() int {
+	return len(q)
+}
+
+func (q lfuQueue[K]) Less(i, j int) bool {
+	if q[i].freq != q[j].freq {
+		return q[i].freq < q[j].freq
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q lfuQueue[K]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *lfuQueue[K]) Push(x interface{}) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *lfuQueue[K]) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	e.index = -1
+	*q = old[0 : n-1]
+	return e
+}
+
+type lfuPolicy[K comparable] struct {
+	sync.Mutex
+	seq     uint64
+	entries map[K]*lfuEntry[K]
+	queue   lfuQueue[K]
+}
+
+// NewLFUPolicy creates an EvictionPolicy that evicts the least frequently used
+// key first. Ties are broken by evicting the least recently used key.
+func NewLFUPolicy[K comparable]() EvictionPolicy[K] {
+	return &lfuPolicy[K]{
+		entries: make(map[K]*lfuEntry[K]),
+	}
+}
+
+func (p *lfuPolicy[K]) Added(key K) {
+	p.Lock()
+	p.seq++
+	if e := p.entries[key]; e != nil {
+		e.freq = 0
+		e.seq = p.seq
+		heap.Fix(&p.queue, e.index)
+	} else {
+		e = &lfuEntry[K]{key: key, seq: p.seq}
+		p.entries[key] = e
+		heap.Push(&p.queue, e)
+	}
+	p.Unlock()
+}
+
+func (p *lfuPolicy[K]) Accessed(key K) {
+	p.Lock()
+	if e := p.entries[key]; e != nil {
+		p.seq++
+		e.freq++
+		e.seq = p.seq
+		heap.Fix(&p.queue, e.index)
+	}
+	p.Unlock()
+}
+
+func (p *lfuPolicy[K]) Removed(key K) {
+	p.Lock()
+	if e := p.entries[key]; e != nil {
+		heap.Remove(&p.queue, e.index)
+		delete(p.entries, key)
+	}
+	p.Unlock()
+}
+
+func (p *lfuPolicy[K]) Victim() (K, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.queue) > 0 {
+		return p.queue[0].key, true
+	}
+	var zero K
+	return zero, false
+}
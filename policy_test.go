@@ -0,0 +1,146 @@
+package ttlmap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testMapBounded(t *testing.T, policy func() EvictionPolicy[string]) []string {
+	var evicted []string
+	opts := &Options{
+		MaxItems:       3,
+		EvictionPolicy: policy,
+		OnWillEvict: func(key string, item Item) {
+			evicted = append(evicted, key)
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	for i := 0; i < 3; i++ {
+		item := NewItem("value", WithTTL(time.Duration(3-i)*time.Minute))
+		if err := m.Set(fmt.Sprintf("%d", i), item, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"0", "0", "1"} {
+		if _, err := m.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("3", NewItem("value", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("3", NewItem("value2", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 3 {
+		t.Fatalf("Invalid length")
+	}
+	return evicted
+}
+
+func TestMapBoundedLRU(t *testing.T) {
+	evicted := testMapBounded(t, NewLRUPolicy[string])
+	if len(evicted) != 2 || evicted[0] != "2" || evicted[1] != "3" {
+		t.Fatalf("Invalid evicted=%v", evicted)
+	}
+}
+
+func TestMapBoundedLFU(t *testing.T) {
+	evicted := testMapBounded(t, NewLFUPolicy[string])
+	if len(evicted) != 2 || evicted[0] != "2" || evicted[1] != "3" {
+		t.Fatalf("Invalid evicted=%v", evicted)
+	}
+}
+
+func TestMapBoundedFIFO(t *testing.T) {
+	evicted := testMapBounded(t, NewFIFOPolicy[string])
+	if len(evicted) != 2 || evicted[0] != "0" || evicted[1] != "3" {
+		t.Fatalf("Invalid evicted=%v", evicted)
+	}
+}
+
+func TestMapBoundedDefault(t *testing.T) {
+	evicted := testMapBounded(t, nil)
+	if len(evicted) != 2 || evicted[0] != "2" || evicted[1] != "3" {
+		t.Fatalf("Invalid evicted=%v", evicted)
+	}
+}
+
+func TestMapBoundedCost(t *testing.T) {
+	var evicted []string
+	opts := &Options{
+		MaxCost:        10,
+		EvictionPolicy: NewLRUPolicy[string],
+		Cost: func(key string, item Item) int64 {
+			return int64(len(item.Value().(string)))
+		},
+		OnWillEvict: func(key string, item Item) {
+			evicted = append(evicted, key)
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := m.Set(key, NewItem("xxx", nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Cost() != 9 || len(evicted) != 0 {
+		t.Fatalf("Invalid cost=%d evicted=%v", m.Cost(), evicted)
+	}
+	if _, err := m.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("d", NewItem("xxxx", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.Cost() != 10 || len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("Invalid cost=%d evicted=%v", m.Cost(), evicted)
+	}
+	if _, err := m.Update("d", NewItem("xxxxxxx", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.Cost() != 10 || m.Len() != 2 || len(evicted) != 2 || evicted[1] != "c" {
+		t.Fatalf("Invalid cost=%d evicted=%v", m.Cost(), evicted)
+	}
+	if _, err := m.Delete("d"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Cost() != 3 {
+		t.Fatalf("Invalid cost=%d", m.Cost())
+	}
+}
+
+func TestMapBoundedCostTooLarge(t *testing.T) {
+	var evicted []string
+	opts := &Options{
+		MaxCost: 5,
+		Cost: func(key string, item Item) int64 {
+			return int64(len(item.Value().(string)))
+		},
+		OnWillEvict: func(key string, item Item) {
+			evicted = append(evicted, key)
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	for _, key := range []string{"a", "b"} {
+		if err := m.Set(key, NewItem("xx", nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("c", NewItem("xxxxxxxxxx", nil), nil); err != ErrTooLarge {
+		t.Fatalf("Expecting ErrTooLarge, got %v", err)
+	}
+	if _, err := m.Update("a", NewItem("xxxxxxxxxx", nil), nil); err != ErrTooLarge {
+		t.Fatalf("Expecting ErrTooLarge, got %v", err)
+	}
+	if m.Cost() != 4 || m.Len() != 2 || len(evicted) != 0 {
+		t.Fatalf("Invalid cost=%d evicted=%v", m.Cost(), evicted)
+	}
+	if item, err := m.Get("a"); err != nil || item.Value() != "xx" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+}
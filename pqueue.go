@@ -1,18 +1,32 @@
 package ttlmap
 
-type pqitem struct {
-	key   string
-	item  *Item
+import "time"
+
+type pqitem[K comparable, V any] struct {
+	key   K
+	item  *TypedItem[V]
 	index int
+	// bucket holds the item in a timingWheel.
+	bucket int
+	cost   int64
+	// sliding is the TTL restored on every read, zero if not sliding.
+	sliding time.Duration
+	// logged is the expiration last recorded in the log, in nanoseconds.
+	logged int64
+	tags   []string
+}
+
+func (pqi *pqitem[K, V]) record() record[K, V] {
+	return record[K, V]{pqi.key, *pqi.item, pqi.sliding, pqi.tags}
 }
 
-type pqueue []*pqitem
+type pqueue[K comparable, V any] []*pqitem[K, V]
 
-func (pq pqueue) Len() int {
+func (pq pqueue[K, V]) Len() int {
 	return len(pq)
 }
 
-func (pq pqueue) Less(i, j int) bool {
+func (pq pqueue[K, V]) Less(i, j int) bool {
 	pqi := pq[i].item
 	pqj := pq[j].item
 	if pqi.expires {
@@ -24,20 +38,20 @@ func (pq pqueue) Less(i, j int) bool {
 	return false
 }
 
-func (pq pqueue) Swap(i, j int) {
+func (pq pqueue[K, V]) Swap(i, j int) {
 	pq[i], pq[j] = pq[j], pq[i]
 	pq[i].index = i
 	pq[j].index = j
 }
 
-func (pq *pqueue) Push(x interface{}) {
+func (pq *pqueue[K, V]) Push(x interface{}) {
 	n := len(*pq)
-	item := x.(*pqitem)
+	item := x.(*pqitem[K, V])
 	item.index = n
 	*pq = append(*pq, item)
 }
 
-func (pq *pqueue) Pop() interface{} {
+func (pq *pqueue[K, V]) Pop() interface{} {
 	old := *pq
 	n := len(old)
 	item := old[n-1]
@@ -46,7 +60,7 @@ func (pq *pqueue) Pop() interface{} {
 	return item
 }
 
-func (pq pqueue) peek() *pqitem {
+func (pq pqueue[K, V]) peek() *pqitem[K, V] {
 	if len(pq) == 0 {
 		return nil
 	}
@@ -0,0 +1,112 @@
+package ttlmap
+
+import (
+	"container/heap"
+	"time"
+)
+
+// schedule orders the items of a store by expiration for its keeper.
+type schedule[K comparable, V any] interface {
+	push(pqi *pqitem[K, V])
+	remove(pqi *pqitem[K, V])
+	// fix reorders an item whose expiration changed.
+	fix(pqi *pqitem[K, V])
+	// head reports whether the keeper has to be rescheduled when the item is
+	// added or removed.
+	head(pqi *pqitem[K, V]) bool
+	// peek returns the item expiring first, if any.
+	peek() *pqitem[K, V]
+	// victim returns the item to evict when the store is full and has no
+	// eviction policy: the item expiring first, or one close to it.
+	victim() *pqitem[K, V]
+	// expired returns an item expired at now, if any.
+	expired(now time.Time) *pqitem[K, V]
+	// next returns the delay until the keeper should run again, if ever.
+	next(now time.Time) (time.Duration, bool)
+	len() int
+	// each calls fn for every item, in no particular order, until fn returns
+	// false.
+	each(fn func(pqi *pqitem[K, V]) bool)
+	// before calls fn for the items expiring before t, in no particular
+	// order.
+	before(t time.Time, fn func(pqi *pqitem[K, V]))
+	clear()
+}
+
+func newSchedule[K comparable, V any](opts *TypedOptions[K, V], now time.Time) schedule[K, V] {
+	if opts.TimingWheel != nil {
+		return newTimingWheel[K, V](opts.TimingWheel, now)
+	}
+	pq := make(pqueue[K, V], 0, opts.InitialCapacity)
+	return &pq
+}
+
+func (pq *pqueue[K, V]) push(pqi *pqitem[K, V]) {
+	heap.Push(pq, pqi)
+}
+
+func (pq *pqueue[K, V]) remove(pqi *pqitem[K, V]) {
+	heap.Remove(pq, pqi.index)
+}
+
+func (pq *pqueue[K, V]) fix(pqi *pqitem[K, V]) {
+	heap.Fix(pq, pqi.index)
+}
+
+func (pq *pqueue[K, V]) head(pqi *pqitem[K, V]) bool {
+	return pqi.index == 0
+}
+
+func (pq *pqueue[K, V]) expired(now time.Time) *pqitem[K, V] {
+	if pqi := pq.peek(); pqi != nil && pqi.item.ExpiredAt(now) {
+		return pqi
+	}
+	return nil
+}
+
+func (pq *pqueue[K, V]) next(now time.Time) (time.Duration, bool) {
+	pqi := pq.peek()
+	if pqi == nil {
+		return 0, false
+	}
+	return pqi.item.TTLAt(now), true
+}
+
+func (pq *pqueue[K, V]) victim() *pqitem[K, V] {
+	return pq.peek()
+}
+
+func (pq *pqueue[K, V]) len() int {
+	return len(*pq)
+}
+
+func (pq *pqueue[K, V]) each(fn func(pqi *pqitem[K, V]) bool) {
+	for _, pqi := range *pq {
+		if !fn(pqi) {
+			return
+		}
+	}
+}
+
+func (pq *pqueue[K, V]) before(t time.Time, fn func(pqi *pqitem[K, V])) {
+	// The items expiring before t are at the top of the heap.
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(*pq) {
+			continue
+		}
+		pqi := (*pq)[i]
+		if !pqi.item.expires || !pqi.item.expiration.Before(t) {
+			continue
+		}
+		fn(pqi)
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+}
+
+func (pq *pqueue[K, V]) clear() {
+	clear(*pq)
+	*pq = (*pq)[:0]
+}
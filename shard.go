@@ -0,0 +1,209 @@
+package ttlmap
+
+import "time"
+
+// shard is an independent partition of a map, with its own store, lock and
+// expiry scheduling.
+type shard[K comparable, V any] struct {
+	store  *store[K, V]
+	keeper *keeper[K, V]
+}
+
+func newShard[K comparable, V any](opts *TypedOptions[K, V]) *shard[K, V] {
+	store := newStore(opts)
+	return &shard[K, V]{
+		store:  store,
+		keeper: newKeeper(store),
+	}
+}
+
+// getLocked retries Get under the write lock, for an item that has to be
+// expired on the spot or whose sliding expiration has to be refreshed.
+func (s *shard[K, V]) getLocked(key K) (TypedItem[V], error) {
+	var zero TypedItem[V]
+	s.store.Lock()
+	if s.keeper.drained {
+		s.store.unlock()
+		return zero, ErrDrained
+	}
+	if pqi := s.lookup(key); pqi != nil {
+		s.read(pqi)
+		item := *pqi.item
+		s.store.unlock()
+		s.store.stats.countGet(nil)
+		return item, nil
+	}
+	s.store.unlock()
+	s.store.stats.countGet(ErrNotExist)
+	return zero, ErrNotExist
+}
+
+// lookup returns the item with the given key. With strict expiration, an
+// expired item is expired on the spot and reported as absent.
+func (s *shard[K, V]) lookup(key K) *pqitem[K, V] {
+	pqi := s.store.kv[key]
+	if pqi != nil && s.store.strict && s.tryExpire(pqi) {
+		return nil
+	}
+	return pqi
+}
+
+// read records a read of the item, refreshing its sliding expiration.
+func (s *shard[K, V]) read(pqi *pqitem[K, V]) {
+	s.store.access(pqi)
+	if pqi.sliding <= 0 {
+		return
+	}
+	head := s.store.sched.head(pqi)
+	pqi.item.expiration = s.store.now().Add(pqi.sliding)
+	s.store.fix(pqi, pqi.cost)
+	// Refreshes are only logged once the expiration moved by half the TTL,
+	// so that frequent reads do not write the log every time.
+	if pqi.item.expiration.UnixNano()-pqi.logged >= int64(pqi.sliding/2) {
+		s.store.log(logUpdate, pqi)
+	}
+	if (head || s.store.sched.head(pqi)) && s.keeper.due(pqi) {
+		s.keeper.signalUpdate()
+	}
+}
+
+// slidingTTL returns the sliding TTL for an item, if sliding is enabled.
+func (s *shard[K, V]) slidingTTL(item *TypedItem[V], sliding bool) time.Duration {
+	if !item.expires || !(sliding || s.store.sliding) {
+		return 0
+	}
+	if ttl := item.TTLAt(s.store.now()); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+func (s *shard[K, V]) set(key K, item *TypedItem[V], opts *SetOptions) error {
+	cost := s.store.cost(key, item)
+	if s.store.tooLarge(cost) {
+		return ErrTooLarge
+	}
+	var old TypedItem[V]
+	if pqi := s.lookup(key); pqi != nil {
+		if opts.keyExist() == KeyExistNotYet {
+			return ErrExist
+		}
+		old = *pqi.item
+		s.expireOrEvict(pqi)
+	} else if opts.keyExist() == KeyExistAlready {
+		return ErrNotExist
+	}
+	pqi := &pqitem[K, V]{
+		key:     key,
+		item:    item,
+		index:   -1,
+		cost:    cost,
+		sliding: s.slidingTTL(item, opts.sliding()),
+		tags:    opts.tags(),
+	}
+	s.makeRoom(1, pqi.cost, nil)
+	s.store.set(pqi)
+	s.store.log(logSet, pqi)
+	s.store.stats.countSet()
+	s.store.notify(TypedEvent[K, V]{Type: EventSet, Key: key, OldItem: old, NewItem: *item})
+	s.schedule(pqi)
+	return nil
+}
+
+func (s *shard[K, V]) update(pqi *pqitem[K, V], item *TypedItem[V], opts *UpdateOptions) error {
+	if opts != nil {
+		if opts.KeepValue {
+			item.value = pqi.item.value
+		}
+		if opts.KeepExpiration {
+			item.expiration = pqi.item.expiration
+			item.expires = pqi.item.expires
+		}
+	}
+	cost := s.store.cost(pqi.key, item)
+	if s.store.tooLarge(cost) {
+		return ErrTooLarge
+	}
+	if opts == nil || !opts.KeepExpiration {
+		pqi.sliding = s.slidingTTL(item, pqi.sliding > 0)
+	}
+	old := *pqi.item
+	pqi.item = item
+	s.store.fix(pqi, cost)
+	s.store.log(logUpdate, pqi)
+	s.store.stats.countUpdate()
+	s.store.notify(TypedEvent[K, V]{Type: EventUpdate, Key: pqi.key, OldItem: old, NewItem: *item})
+	s.store.access(pqi)
+	s.schedule(pqi)
+	s.makeRoom(0, 0, pqi)
+	return nil
+}
+
+// schedule signals the keeper if the item is added or removed at the head of
+// the schedule.
+func (s *shard[K, V]) schedule(pqi *pqitem[K, V]) {
+	if s.store.sched.head(pqi) && s.keeper.due(pqi) {
+		s.keeper.signalUpdate()
+	}
+}
+
+// makeRoom evicts victims until n more items of the given cost fit in the
+// shard. The skipped item is never evicted to make room for itself.
+func (s *shard[K, V]) makeRoom(n int, cost int64, skip *pqitem[K, V]) {
+	for s.store.overflows(n, cost) {
+		pqi := s.store.victim()
+		if pqi == nil || pqi == skip {
+			return
+		}
+		s.expireOrEvict(pqi)
+	}
+}
+
+func (s *shard[K, V]) tryExpire(pqi *pqitem[K, V]) bool {
+	if !s.store.expired(pqi.item) {
+		return false
+	}
+	s.schedule(pqi)
+	return s.store.tryExpire(pqi)
+}
+
+func (s *shard[K, V]) evictExpired() {
+	if s.store.sched.expired(s.store.now()) != nil {
+		s.keeper.signalUpdate()
+		s.store.evictExpired()
+	}
+}
+
+func (s *shard[K, V]) expireOrEvict(pqi *pqitem[K, V]) {
+	s.schedule(pqi)
+	if !s.store.tryExpire(pqi) {
+		s.store.evict(pqi)
+	}
+}
+
+func (s *shard[K, V]) delete(pqi *pqitem[K, V]) {
+	s.schedule(pqi)
+	s.store.log(logDelete, pqi)
+	s.store.stats.countDelete()
+	s.store.notify(TypedEvent[K, V]{Type: EventDelete, Key: pqi.key, OldItem: *pqi.item})
+	s.store.delete(pqi)
+}
+
+// popExpired removes expired items into items until it holds max of them,
+// without calling the callbacks.
+func (s *shard[K, V]) popExpired(max int, items map[K]TypedItem[V]) {
+	now := s.store.now()
+	for max <= 0 || len(items) < max {
+		pqi := s.store.sched.expired(now)
+		if pqi == nil {
+			return
+		}
+		s.keeper.signalUpdate()
+		s.store.stats.countExpire(now.Sub(pqi.item.expiration))
+		s.store.log(logExpire, pqi)
+		s.store.notifyWatchers(TypedEvent[K, V]{Type: EventExpire, Key: pqi.key, OldItem: *pqi.item})
+		s.store.notifyWatchers(TypedEvent[K, V]{Type: EventEvict, Key: pqi.key, OldItem: *pqi.item})
+		s.store.delete(pqi)
+		items[pqi.key] = *pqi.item
+	}
+}
@@ -0,0 +1,164 @@
+package ttlmap
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapSharded(t *testing.T) {
+	var expired, evicted int64
+	opts := &Options{
+		Shards: 8,
+		OnWillExpire: func(key string, item Item) {
+			atomic.AddInt64(&expired, 1)
+		},
+		OnWillEvict: func(key string, item Item) {
+			atomic.AddInt64(&evicted, 1)
+		},
+	}
+	m := New(opts)
+	defer m.Drain()
+	if len(m.shards) != 8 {
+		t.Fatalf("Invalid shards")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d-%d", i, j)
+				ttl := 100 * time.Millisecond
+				if j%2 == 0 {
+					ttl = time.Hour
+				}
+				if err := m.Set(key, NewItem(key, WithTTL(ttl)), nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if m.Len() != 400 {
+		t.Fatalf("Invalid length")
+	}
+	if item, err := m.Get("3-42"); err != nil || item.Value() != "3-42" {
+		t.Fatalf("Invalid item=%v err=%v", item, err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if m.Len() != 200 {
+		t.Fatalf("Invalid length")
+	}
+	m.Drain()
+	select {
+	case <-m.Draining():
+	default:
+		t.Fatalf("Expecting draining")
+	}
+	if atomic.LoadInt64(&expired) != 200 || atomic.LoadInt64(&evicted) != 400 {
+		t.Fatalf("Invalid expired=%d evicted=%d", expired, evicted)
+	}
+	if _, err := m.Get("3-42"); err != ErrDrained {
+		t.Fatal(err)
+	}
+}
+
+func TestMapShardedMaxItems(t *testing.T) {
+	for _, tc := range []struct {
+		shards   int
+		maxItems int
+	}{
+		{4, 100},
+		{8, 10},
+		{4, 1},
+	} {
+		m := New(&Options{Shards: tc.shards, MaxItems: tc.maxItems})
+		for i := 0; i < 1000; i++ {
+			if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, nil), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := m.Len(); n > tc.maxItems {
+			t.Fatalf("Invalid length %d for %d shards and %d items", n, tc.shards, tc.maxItems)
+		}
+		total := 0
+		for _, s := range m.shards {
+			total += s.store.maxItems
+		}
+		if total != tc.maxItems {
+			t.Fatalf("Invalid total of shard limits %d for %d shards and %d items", total, tc.shards, tc.maxItems)
+		}
+		m.Drain()
+	}
+}
+
+func benchmarkMapSetParallel(b *testing.B, shards int) {
+	b.StopTimer()
+	m := New(&Options{Shards: shards})
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d", i)
+	}
+	value := NewItem("bar", WithTTL(30*time.Minute))
+	b.StartTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if err := m.Set(keys[i%len(keys)], value, nil); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	m.Drain()
+}
+
+func BenchmarkMapSetParallel1Shard(b *testing.B) {
+	benchmarkMapSetParallel(b, 1)
+}
+
+func BenchmarkMapSetParallel16Shards(b *testing.B) {
+	benchmarkMapSetParallel(b, 16)
+}
+
+func benchmarkMapGetSetParallel(b *testing.B, shards int) {
+	b.StopTimer()
+	m := New(&Options{Shards: shards})
+	keys := make([]string, 1024)
+	value := NewItem("bar", WithTTL(30*time.Minute))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d", i)
+		if err := m.Set(keys[i], value, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StartTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%4 == 0 {
+				if err := m.Set(key, value, nil); err != nil {
+					b.Fatal(err)
+				}
+			} else if _, err := m.Get(key); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+	m.Drain()
+}
+
+func BenchmarkMapGetSetParallel1Shard(b *testing.B) {
+	benchmarkMapGetSetParallel(b, 1)
+}
+
+func BenchmarkMapGetSetParallel16Shards(b *testing.B) {
+	benchmarkMapGetSetParallel(b, 16)
+}
@@ -0,0 +1,221 @@
+package ttlmap
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExpvarExists is returned by PublishExpvar if the name is already taken.
+var ErrExpvarExists = errors.New("expvar variable already exists")
+
+// Stats are counters and timings describing the activity of a map.
+type Stats struct {
+	Gets        uint64
+	Hits        uint64
+	Misses      uint64
+	Sets        uint64
+	Updates     uint64
+	Deletes     uint64
+	Expirations uint64
+	Evictions   uint64
+	Drains      uint64
+	// Items is the current number of items in the expiration queues.
+	Items int
+	// ExpirationDelay is the total time items spent expired before being
+	// removed, and MaxExpirationDelay the longest.
+	ExpirationDelay    time.Duration
+	MaxExpirationDelay time.Duration
+	// Sweeps is the number of times the keeper collected expired items,
+	// SweepTime the total time it held the lock doing so, and MaxSweepTime
+	// the longest.
+	Sweeps       uint64
+	SweepTime    time.Duration
+	MaxSweepTime time.Duration
+}
+
+// StatsProvider is implemented by maps reporting Stats.
+type StatsProvider interface {
+	Stats() Stats
+}
+
+type storeStats struct {
+	gets         atomic.Uint64
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	sets         atomic.Uint64
+	updates      atomic.Uint64
+	deletes      atomic.Uint64
+	expirations  atomic.Uint64
+	evictions    atomic.Uint64
+	delay        atomic.Int64
+	maxDelay     atomic.Int64
+	sweeps       atomic.Uint64
+	sweepTime    atomic.Int64
+	maxSweepTime atomic.Int64
+}
+
+// The counters are only updated if Options.Stats is set, as they are shared by
+// the readers of a shard. The methods do nothing on a nil storeStats.
+
+func (s *storeStats) countGet(err error) {
+	if s == nil {
+		return
+	}
+	s.gets.Add(1)
+	if err == nil {
+		s.hits.Add(1)
+	} else if err == ErrNotExist {
+		s.misses.Add(1)
+	}
+}
+
+func (s *storeStats) countSet() {
+	if s != nil {
+		s.sets.Add(1)
+	}
+}
+
+func (s *storeStats) countUpdate() {
+	if s != nil {
+		s.updates.Add(1)
+	}
+}
+
+func (s *storeStats) countDelete() {
+	if s != nil {
+		s.deletes.Add(1)
+	}
+}
+
+func (s *storeStats) countEvict(n int) {
+	if s != nil {
+		s.evictions.Add(uint64(n))
+	}
+}
+
+func (s *storeStats) countExpire(delay time.Duration) {
+	if s == nil {
+		return
+	}
+	s.expirations.Add(1)
+	s.delay.Add(int64(delay))
+	storeMax(&s.maxDelay, int64(delay))
+}
+
+func (s *storeStats) countSweep(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.sweeps.Add(1)
+	s.sweepTime.Add(int64(d))
+	storeMax(&s.maxSweepTime, int64(d))
+}
+
+func storeMax(max *atomic.Int64, v int64) {
+	for {
+		old := max.Load()
+		if v <= old || max.CompareAndSwap(old, v) {
+			return
+		}
+	}
+}
+
+func (s *storeStats) addTo(stats *Stats) {
+	if s == nil {
+		return
+	}
+	stats.Gets += s.gets.Load()
+	stats.Hits += s.hits.Load()
+	stats.Misses += s.misses.Load()
+	stats.Sets += s.sets.Load()
+	stats.Updates += s.updates.Load()
+	stats.Deletes += s.deletes.Load()
+	stats.Expirations += s.expirations.Load()
+	stats.Evictions += s.evictions.Load()
+	stats.ExpirationDelay += time.Duration(s.delay.Load())
+	stats.MaxExpirationDelay = max(stats.MaxExpirationDelay, time.Duration(s.maxDelay.Load()))
+	stats.Sweeps += s.sweeps.Load()
+	stats.SweepTime += time.Duration(s.sweepTime.Load())
+	stats.MaxSweepTime = max(stats.MaxSweepTime, time.Duration(s.maxSweepTime.Load()))
+}
+
+// Stats returns the counters and timings of the map since it was created.
+// Only Items and Drains are reported unless Options.Stats is set.
+func (m *TypedMap[K, V]) Stats() Stats {
+	stats := Stats{Drains: m.drains.Load()}
+	for _, s := range m.shards {
+		s.store.stats.addTo(&stats)
+		s.store.RLock()
+		stats.Items += s.store.sched.len()
+		s.store.RUnlock()
+	}
+	return stats
+}
+
+// expvarMu serializes PublishExpvar, so that checking the name and publishing
+// are atomic among its callers.
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the Stats of a map as an expvar variable with the
+// given name. ErrExpvarExists will be returned if a variable with that name
+// was already published, as expvar variables cannot be removed.
+func PublishExpvar(name string, p StatsProvider) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return ErrExpvarExists
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.Stats()
+	}))
+	return nil
+}
+
+type prometheusMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(stats *Stats) float64
+}
+
+func counter(v uint64) float64 {
+	return float64(v)
+}
+
+var prometheusMetrics = []prometheusMetric{
+	{"gets_total", "counter", "Number of Get calls.", func(s *Stats) float64 { return counter(s.Gets) }},
+	{"hits_total", "counter", "Number of Get calls finding the key.", func(s *Stats) float64 { return counter(s.Hits) }},
+	{"misses_total", "counter", "Number of Get calls not finding the key.", func(s *Stats) float64 { return counter(s.Misses) }},
+	{"sets_total", "counter", "Number of items set.", func(s *Stats) float64 { return counter(s.Sets) }},
+	{"updates_total", "counter", "Number of items updated.", func(s *Stats) float64 { return counter(s.Updates) }},
+	{"deletes_total", "counter", "Number of items deleted.", func(s *Stats) float64 { return counter(s.Deletes) }},
+	{"expirations_total", "counter", "Number of items expired.", func(s *Stats) float64 { return counter(s.Expirations) }},
+	{"evictions_total", "counter", "Number of items evicted before expiring.", func(s *Stats) float64 { return counter(s.Evictions) }},
+	{"drains_total", "counter", "Number of drains.", func(s *Stats) float64 { return counter(s.Drains) }},
+	{"items", "gauge", "Number of items in the expiration queues.", func(s *Stats) float64 { return float64(s.Items) }},
+	{"expiration_delay_seconds_total", "counter", "Total time items spent expired before removal.", func(s *Stats) float64 { return s.ExpirationDelay.Seconds() }},
+	{"expiration_delay_max_seconds", "gauge", "Longest time an item spent expired before removal.", func(s *Stats) float64 { return s.MaxExpirationDelay.Seconds() }},
+	{"sweeps_total", "counter", "Number of expiration sweeps.", func(s *Stats) float64 { return counter(s.Sweeps) }},
+	{"sweep_seconds_total", "counter", "Total time the lock was held by expiration sweeps.", func(s *Stats) float64 { return s.SweepTime.Seconds() }},
+	{"sweep_max_seconds", "gauge", "Longest time the lock was held by an expiration sweep.", func(s *Stats) float64 { return s.MaxSweepTime.Seconds() }},
+}
+
+// PrometheusHandler returns an http.Handler serving the Stats of a map in the
+// Prometheus text exposition format, with metric names prefixed by namespace.
+func PrometheusHandler(namespace string, p StatsProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := p.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, metric := range prometheusMetrics {
+			name := namespace + "_" + metric.name
+			fmt.Fprintf(w, "# HELP %s %s\n", name, metric.help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, metric.kind)
+			fmt.Fprintf(w, "%s %g\n", name, metric.value(&stats))
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package ttlmap
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapStats(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	m := New(&Options{Clock: clock, MaxItems: 3, Stats: true})
+	defer m.Drain()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := m.Set(key, NewItem(key, WithTTLFrom(clock, time.Second)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Get("b")
+	m.Get("a")
+	if _, err := m.Update("b", NewItem("b", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(3 * time.Second)
+	stats := m.Stats()
+	expected := Stats{
+		Gets:               2,
+		Hits:               1,
+		Misses:             1,
+		Sets:               4,
+		Updates:            1,
+		Deletes:            1,
+		Expirations:        2,
+		Evictions:          1,
+		MaxExpirationDelay: time.Nanosecond,
+		ExpirationDelay:    2 * time.Nanosecond,
+	}
+	stats.Sweeps, stats.SweepTime, stats.MaxSweepTime = 0, 0, 0
+	if stats != expected {
+		t.Fatalf("Invalid stats %+v", stats)
+	}
+	m.Drain()
+	if stats := m.Stats(); stats.Drains != 1 {
+		t.Fatalf("Invalid stats %+v", stats)
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	m := New(&Options{Stats: true})
+	defer m.Drain()
+	m.Set("foo", NewItem("bar", nil), nil)
+	m.Get("foo")
+	rec := httptest.NewRecorder()
+	PrometheusHandler("cache", m).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE cache_gets_total counter\ncache_gets_total 1\n",
+		"cache_hits_total 1\n",
+		"# TYPE cache_items gauge\ncache_items 1\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("Expecting %q in:\n%s", line, body)
+		}
+	}
+}
+
+var expvarTests atomic.Int32
+
+func TestPublishExpvar(t *testing.T) {
+	m := New(&Options{Stats: true})
+	defer m.Drain()
+	m.Set("foo", NewItem("bar", nil), nil)
+	// Tests may run several times in a process, and expvar variables cannot
+	// be removed.
+	name := fmt.Sprintf("ttlmap_test_%d", expvarTests.Add(1))
+	if err := PublishExpvar(name, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishExpvar(name, m); err != ErrExpvarExists {
+		t.Fatalf("Expecting ErrExpvarExists, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	var stats Stats
+	if err := json.Unmarshal(vars[name], &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sets != 1 || stats.Items != 1 {
+		t.Fatalf("Invalid stats %+v", stats)
+	}
+}
+
+func TestMapStatsDisabled(t *testing.T) {
+	m := New(nil)
+	m.Set("foo", NewItem("bar", nil), nil)
+	m.Get("foo")
+	m.Drain()
+	if stats := m.Stats(); stats != (Stats{Drains: 1}) {
+		t.Fatalf("Invalid stats %+v", stats)
+	}
+}
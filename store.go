@@ -1,73 +1,405 @@
 package ttlmap
 
 import (
-	"container/heap"
+	"slices"
 	"sync"
+	"time"
 )
 
-type store struct {
+type store[K comparable, V any] struct {
 	sync.RWMutex
-	kv           map[string]*pqitem
-	pq           pqueue
-	onWillExpire func(key string, item Item)
-	onWillEvict  func(key string, item Item)
+	kv    map[K]*pqitem[K, V]
+	sched schedule[K, V]
+	// tags indexes the keys of the tagged items by tag.
+	tags map[string]map[K]struct{}
+	// index keeps the keys in order, with Options.OrderedKeys.
+	index     *keyIndex[K]
+	callbacks *callbacks[K, V]
+	maxItems  int
+	policy    EvictionPolicy[K]
+	maxCost   int64
+	costFunc  func(key K, item TypedItem[V]) int64
+	totalCost int64
+	strict    bool
+	// sweepSize and sweepDuration bound the batches of the keeper.
+	sweepSize     int
+	sweepDuration time.Duration
+	tolerance     time.Duration
+	sliding       bool
+	journal       *journal[K, V]
+	clock         Clock
+	stats         *storeStats
+	dispatcher    *dispatcher[K, V]
+	hub           *hub[K, V]
+	events        []TypedEvent[K, V]
+	// quiet holds the indices of the events only sent to the watchers.
+	quiet []int
+	// eventCallbacks are the callbacks in use when the events were queued.
+	eventCallbacks *callbacks[K, V]
+	// seq numbers the batches of events in the order they are queued, under
+	// the write lock. Batches are published in that order once the lock is
+	// released, and turn is the number of the next one to publish. pending
+	// holds the batches handed over by callbacks until their turn.
+	seq      uint64
+	turnMu   sync.Mutex
+	turnCond sync.Cond
+	turn     uint64
+	pending  []eventBatch[K, V]
 }
 
-func newStore(opts *Options) *store {
-	return &store{
-		kv:           make(map[string]*pqitem, opts.InitialCapacity),
-		pq:           make(pqueue, 0, opts.InitialCapacity),
-		onWillExpire: opts.OnWillExpire,
-		onWillEvict:  opts.OnWillEvict,
+func newStore[K comparable, V any](opts *TypedOptions[K, V]) *store[K, V] {
+	s := &store[K, V]{clock: opts.Clock}
+	if opts.Stats {
+		s.stats = &storeStats{}
+	}
+	s.turnCond.L = &s.turnMu
+	s.reset(opts)
+	return s
+}
+
+// reset empties the store and applies the options, except for the clock.
+func (s *store[K, V]) reset(opts *TypedOptions[K, V]) {
+	s.kv = make(map[K]*pqitem[K, V], opts.InitialCapacity)
+	s.sched = newSchedule(opts, s.clock.Now())
+	s.tags = nil
+	s.index = nil
+	if compare := keyCompare(opts); compare != nil {
+		s.index = newKeyIndex(compare)
 	}
+	s.totalCost = 0
+	s.callbacks = newCallbacks(opts)
+	s.maxItems = opts.MaxItems
+	s.policy = newPolicy(opts.EvictionPolicy)
+	s.maxCost = opts.MaxCost
+	s.costFunc = opts.Cost
+	s.strict = opts.StrictExpiration
+	s.sliding = opts.SlidingExpiration
+	s.sweepSize, s.sweepDuration = opts.Sweep.limits()
+	s.tolerance = opts.ExpirationTolerance
 }
 
-func (s *store) set(pqi *pqitem) {
+func (s *store[K, V]) set(pqi *pqitem[K, V]) {
 	s.kv[pqi.key] = pqi
-	heap.Push(&s.pq, pqi)
+	s.totalCost += pqi.cost
+	s.sched.push(pqi)
+	if s.policy != nil {
+		s.policy.Added(pqi.key)
+	}
+	if s.index != nil {
+		s.index.insert(pqi.key)
+	}
+	for _, tag := range pqi.tags {
+		if s.tags == nil {
+			s.tags = make(map[string]map[K]struct{})
+		}
+		keys := s.tags[tag]
+		if keys == nil {
+			keys = make(map[K]struct{})
+			s.tags[tag] = keys
+		}
+		keys[pqi.key] = struct{}{}
+	}
 }
 
-func (s *store) delete(pqi *pqitem) {
+func (s *store[K, V]) delete(pqi *pqitem[K, V]) {
 	delete(s.kv, pqi.key)
-	heap.Remove(&s.pq, pqi.index)
+	s.totalCost -= pqi.cost
+	s.sched.remove(pqi)
+	if s.policy != nil {
+		s.policy.Removed(pqi.key)
+	}
+	if s.index != nil {
+		s.index.remove(pqi.key)
+	}
+	for _, tag := range pqi.tags {
+		if keys := s.tags[tag]; keys != nil {
+			delete(keys, pqi.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
+
+func (s *store[K, V]) access(pqi *pqitem[K, V]) {
+	if s.policy != nil {
+		s.policy.Accessed(pqi.key)
+	}
+}
+
+func (s *store[K, V]) overflows(n int, cost int64) bool {
+	if s.maxItems > 0 && len(s.kv)+n > s.maxItems {
+		return true
+	}
+	return s.maxCost > 0 && len(s.kv) > 0 && s.totalCost+cost > s.maxCost
+}
+
+// tooLarge reports whether an item of the given cost cannot fit in the store,
+// even once every other item is evicted.
+func (s *store[K, V]) tooLarge(cost int64) bool {
+	return s.maxCost > 0 && cost > s.maxCost
 }
 
-func (s *store) fix(pqi *pqitem) {
-	heap.Fix(&s.pq, pqi.index)
+func (s *store[K, V]) victim() *pqitem[K, V] {
+	if s.policy != nil {
+		if key, ok := s.policy.Victim(); ok {
+			return s.kv[key]
+		}
+		return nil
+	}
+	return s.sched.victim()
+}
+
+// fix reschedules an item whose expiration or cost changed.
+func (s *store[K, V]) fix(pqi *pqitem[K, V], cost int64) {
+	s.totalCost += cost - pqi.cost
+	pqi.cost = cost
+	s.sched.fix(pqi)
+}
+
+func (s *store[K, V]) cost(key K, item *TypedItem[V]) int64 {
+	if s.costFunc != nil {
+		return s.costFunc(key, *item)
+	}
+	return 1
+}
+
+func (s *store[K, V]) now() time.Time {
+	return s.clock.Now()
 }
 
-func (s *store) tryExpire(pqi *pqitem) bool {
-	if pqi.item.Expired() {
-		if s.onWillExpire != nil {
-			s.onWillExpire(pqi.key, *pqi.item)
+func (s *store[K, V]) expired(item *TypedItem[V]) bool {
+	return item.ExpiredAt(s.clock.Now())
+}
+
+// unlock releases the write lock, then dispatches the events queued while it
+// was held to the callbacks and watchers.
+func (s *store[K, V]) unlock() {
+	s.publish(s.release())
+}
+
+// eventBatch is the events queued while the write lock was held, numbered
+// to be published in order.
+type eventBatch[K comparable, V any] struct {
+	seq       uint64
+	events    []TypedEvent[K, V]
+	quiet     []int
+	callbacks *callbacks[K, V]
+}
+
+// release releases the write lock and returns the queued events.
+func (s *store[K, V]) release() eventBatch[K, V] {
+	if len(s.events) == 0 {
+		s.Unlock()
+		return eventBatch[K, V]{}
+	}
+	b := eventBatch[K, V]{seq: s.seq, events: s.events, quiet: s.quiet, callbacks: s.eventCallbacks}
+	s.seq++
+	s.events, s.quiet, s.eventCallbacks = nil, nil, nil
+	s.Unlock()
+	return b
+}
+
+// publish waits for the batches released before b to be published, then
+// publishes b. The write lock is not held meanwhile, so only the goroutines
+// publishing events wait for a full dispatch queue. Callbacks run by the
+// dispatcher must not wait, as the batches before b may be waiting for room in
+// their queue, so b is handed to the goroutine publishing those instead.
+func (s *store[K, V]) publish(b eventBatch[K, V]) {
+	if len(b.events) == 0 {
+		return
+	}
+	s.turnMu.Lock()
+	if s.turn != b.seq && s.dispatcher != nil && s.dispatcher.calling() {
+		s.pending = append(s.pending, b)
+		s.turnMu.Unlock()
+		return
+	}
+	for s.turn != b.seq {
+		s.turnCond.Wait()
+	}
+	for {
+		s.turnMu.Unlock()
+		if s.dispatcher != nil {
+			s.dispatcher.dispatch(b.events, b.quiet, b.callbacks)
+		}
+		s.hub.publish(b.events)
+		s.turnMu.Lock()
+		s.turn++
+		s.turnCond.Broadcast()
+		i := slices.IndexFunc(s.pending, func(p eventBatch[K, V]) bool {
+			return p.seq == s.turn
+		})
+		if i < 0 {
+			s.turnMu.Unlock()
+			return
 		}
-		s.evict(pqi)
+		b = s.pending[i]
+		s.pending = slices.Delete(s.pending, i, i+1)
+	}
+}
+
+// flush waits for the batches released so far to be published.
+func (s *store[K, V]) flush() {
+	s.RLock()
+	seq := s.seq
+	s.RUnlock()
+	s.turnMu.Lock()
+	for s.turn != seq {
+		s.turnCond.Wait()
+	}
+	s.turnMu.Unlock()
+}
+
+// notify queues an event for the callbacks and watchers. Without a
+// dispatcher, callbacks are called right away.
+func (s *store[K, V]) notify(ev TypedEvent[K, V]) {
+	removal := ev.Type.removal()
+	if removal && s.dispatcher == nil {
+		s.callbacks.call(&ev)
+	}
+	if (removal && s.dispatcher != nil) || s.hub.active() {
+		if len(s.events) == 0 {
+			s.eventCallbacks = s.callbacks
+		}
+		s.events = append(s.events, ev)
+	}
+}
+
+// notifyWatchers queues an event for the watchers only, skipping the
+// callbacks.
+func (s *store[K, V]) notifyWatchers(ev TypedEvent[K, V]) {
+	if !s.hub.active() {
+		return
+	}
+	if len(s.events) == 0 {
+		s.eventCallbacks = s.callbacks
+	}
+	s.quiet = append(s.quiet, len(s.events))
+	s.events = append(s.events, ev)
+}
+
+func (s *store[K, V]) notifyExpire(key K, item TypedItem[V]) {
+	s.notify(TypedEvent[K, V]{Type: EventExpire, Key: key, OldItem: item})
+}
+
+func (s *store[K, V]) notifyEvict(key K, item TypedItem[V]) {
+	s.notify(TypedEvent[K, V]{Type: EventEvict, Key: key, OldItem: item})
+}
+
+func (s *store[K, V]) tryExpire(pqi *pqitem[K, V]) bool {
+	if now := s.now(); pqi.item.ExpiredAt(now) {
+		s.stats.countExpire(now.Sub(pqi.item.expiration))
+		s.notifyExpire(pqi.key, *pqi.item)
+		s.evictAs(pqi, logExpire)
 		return true
 	}
 	return false
 }
 
-func (s *store) evict(pqi *pqitem) {
-	if s.onWillEvict != nil {
-		s.onWillEvict(pqi.key, *pqi.item)
+func (s *store[K, V]) evict(pqi *pqitem[K, V]) {
+	s.evictAs(pqi, logEvict)
+}
+
+func (s *store[K, V]) evictAs(pqi *pqitem[K, V], op byte) {
+	if op == logEvict {
+		s.stats.countEvict(1)
 	}
+	s.notifyEvict(pqi.key, *pqi.item)
+	s.log(op, pqi)
 	s.delete(pqi)
 }
 
-func (s *store) evictExpired() {
-	for pqi := s.pq.peek(); pqi != nil; pqi = s.pq.peek() {
+func (s *store[K, V]) log(op byte, pqi *pqitem[K, V]) {
+	if s.journal != nil {
+		s.journal.append(op, pqi)
+		pqi.logged = pqi.item.expiration.UnixNano()
+	}
+}
+
+func (s *store[K, V]) evictExpired() {
+	s.sweep(0, 0)
+}
+
+// sweep expires the due items, stopping after size items or once it ran for
+// d, if they are positive. It reports whether due items remain.
+func (s *store[K, V]) sweep(size int, d time.Duration) bool {
+	now := s.now()
+	start := time.Now()
+	n := 0
+	for pqi := s.sched.expired(now); pqi != nil; pqi = s.sched.expired(now) {
 		if !s.tryExpire(pqi) {
-			return
+			return false
+		}
+		n++
+		if (size > 0 && n >= size) || (d > 0 && time.Since(start) >= d) {
+			return s.sched.expired(now) != nil
 		}
 	}
+	return false
 }
 
-func (s *store) drain() {
-	for _, pqi := range s.pq {
-		if s.onWillEvict != nil {
-			s.onWillEvict(pqi.key, *pqi.item)
+// clear removes every item, notifying EventClear for each. The caller logs
+// the clear once for all shards.
+func (s *store[K, V]) clear() {
+	if s.sched.len() == 0 {
+		return
+	}
+	s.sched.each(func(pqi *pqitem[K, V]) bool {
+		s.notify(TypedEvent[K, V]{Type: EventClear, Key: pqi.key, OldItem: *pqi.item})
+		if s.policy != nil {
+			s.policy.Removed(pqi.key)
 		}
+		return true
+	})
+	clear(s.kv)
+	clear(s.tags)
+	if s.index != nil {
+		s.index = newKeyIndex(s.index.compare)
 	}
+	s.sched.clear()
+	s.totalCost = 0
+}
+
+func (s *store[K, V]) drain() {
+	s.stats.countEvict(s.sched.len())
+	s.sched.each(func(pqi *pqitem[K, V]) bool {
+		s.notifyEvict(pqi.key, *pqi.item)
+		return true
+	})
 	s.kv = nil
-	s.pq = nil
+	s.sched.clear()
+	s.tags = nil
+	s.index = nil
+	s.totalCost = 0
+}
+
+// callbacks are the removal callbacks of a map, replaced by Reset.
+type callbacks[K comparable, V any] struct {
+	onWillExpire func(key K, item TypedItem[V])
+	onWillEvict  func(key K, item TypedItem[V])
+	onWillClear  func(key K, item TypedItem[V])
+}
+
+func newCallbacks[K comparable, V any](opts *TypedOptions[K, V]) *callbacks[K, V] {
+	return &callbacks[K, V]{
+		onWillExpire: opts.OnWillExpire,
+		onWillEvict:  opts.OnWillEvict,
+		onWillClear:  opts.OnWillClear,
+	}
+}
+
+func (cb *callbacks[K, V]) call(ev *TypedEvent[K, V]) {
+	var fn func(key K, item TypedItem[V])
+	switch ev.Type {
+	case EventExpire:
+		fn = cb.onWillExpire
+	case EventEvict:
+		fn = cb.onWillEvict
+	case EventClear:
+		fn = cb.onWillClear
+	}
+	if fn != nil {
+		fn(ev.Key, ev.OldItem)
+	}
 }
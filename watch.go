@@ -0,0 +1,327 @@
+package ttlmap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWatcherOverflow is returned by Watcher.Err once a WatchBlock watcher
+// fell so far behind that its queue was full.
+var ErrWatcherOverflow = errors.New("watcher queue overflowed")
+
+// EventType is the kind of change described by an Event.
+type EventType int
+
+const (
+	// EventSet is sent when an item is set. OldItem is the replaced item,
+	// if any.
+	EventSet EventType = iota + 1
+	// EventUpdate is sent when an item is updated.
+	EventUpdate
+	// EventDelete is sent when an item is deleted.
+	EventDelete
+	// EventExpire is sent when an item expires. It is followed by an
+	// EventEvict for the same item.
+	EventExpire
+	// EventEvict is sent when an item is removed by the map, because it
+	// expired, was replaced, made room for another item, or the map drained.
+	EventEvict
+	// EventDrain is sent once the map is drained, before the watch channels
+	// are closed.
+	EventDrain
+	// EventClear is sent for every item removed by Clear or Reset.
+	EventClear
+)
+
+// removal reports whether the event is delivered to a removal callback.
+func (t EventType) removal() bool {
+	return t == EventExpire || t == EventEvict || t == EventClear
+}
+
+// TypedEvent describes a change to a TypedMap.
+type TypedEvent[K comparable, V any] struct {
+	Type    EventType
+	Key     K
+	OldItem TypedItem[V]
+	NewItem TypedItem[V]
+}
+
+// Event describes a change to a Map.
+type Event = TypedEvent[string, interface{}]
+
+// SlowWatcherPolicy decides what happens to events for a watcher whose
+// channel is full.
+type SlowWatcherPolicy int
+
+const (
+	// WatchDrop drops the event and counts it in Watcher.Dropped.
+	WatchDrop SlowWatcherPolicy = 0
+	// WatchBlock queues the event until the watcher receives it, or its
+	// context is done. Events are sent by a goroutine of the watcher, so a
+	// watcher that stops reading does not hold up the map. The queue holds
+	// up to BufferSize events on top of the channel; past that, the watcher
+	// is closed once the queued events are received, and Err returns
+	// ErrWatcherOverflow, so that it never silently misses an event.
+	WatchBlock SlowWatcherPolicy = 1
+)
+
+// WatchOptions selects the events sent to a watcher.
+type WatchOptions[K comparable] struct {
+	// Keys restricts the events to the given keys.
+	Keys []K
+	// Prefix restricts the events to keys starting with the prefix. It only
+	// matches keys of type string.
+	Prefix string
+	// Policy for events sent while the channel is full.
+	Policy SlowWatcherPolicy
+	// BufferSize of the channel. Defaults to 64.
+	BufferSize int
+}
+
+// Watcher receives the events of a map until its context is done or the map
+// is drained.
+type Watcher[K comparable, V any] struct {
+	ctx     context.Context
+	c       chan TypedEvent[K, V]
+	keys    map[K]struct{}
+	prefix  string
+	policy  SlowWatcherPolicy
+	dropped atomic.Uint64
+	// mu guards the events queued for a WatchBlock watcher, up to size of
+	// them, and wake signals its goroutine. overflow is closed once the
+	// queue overflowed.
+	mu       sync.Mutex
+	queue    []TypedEvent[K, V]
+	size     int
+	closed   bool
+	err      error
+	wake     chan struct{}
+	overflow chan struct{}
+}
+
+// Events returns the channel receiving the events. It is closed when the
+// watch context is done or after the EventDrain event.
+func (w *Watcher[K, V]) Events() <-chan TypedEvent[K, V] {
+	return w.c
+}
+
+// Dropped returns the number of events dropped because the channel was full.
+func (w *Watcher[K, V]) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Err returns ErrWatcherOverflow if the watcher was closed because its queue
+// overflowed, and nil otherwise.
+func (w *Watcher[K, V]) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Watcher[K, V]) match(ev *TypedEvent[K, V]) bool {
+	if ev.Type == EventDrain {
+		return true
+	}
+	if w.keys != nil {
+		if _, ok := w.keys[ev.Key]; !ok {
+			return false
+		}
+	}
+	if w.prefix != "" {
+		key, ok := interface{}(ev.Key).(string)
+		return ok && strings.HasPrefix(key, w.prefix)
+	}
+	return true
+}
+
+func (w *Watcher[K, V]) send(ev TypedEvent[K, V]) {
+	if w.policy == WatchBlock {
+		w.mu.Lock()
+		switch {
+		case w.closed:
+		case len(w.queue) < w.size:
+			w.queue = append(w.queue, ev)
+		default:
+			w.closed = true
+			w.err = ErrWatcherOverflow
+			close(w.overflow)
+		}
+		w.mu.Unlock()
+		w.signal()
+		return
+	}
+	select {
+	case w.c <- ev:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+func (w *Watcher[K, V]) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued events of a WatchBlock watcher until it is closed or
+// its context is done, then closes its channel.
+func (w *Watcher[K, V]) run() {
+	defer close(w.c)
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			closed := w.closed
+			w.mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-w.wake:
+				continue
+			case <-w.ctx.Done():
+				w.stop()
+				return
+			}
+		}
+		ev := w.queue[0]
+		w.queue[0] = TypedEvent[K, V]{}
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+		select {
+		case w.c <- ev:
+		case <-w.ctx.Done():
+			w.stop()
+			return
+		}
+	}
+}
+
+func (w *Watcher[K, V]) stop() {
+	w.mu.Lock()
+	w.closed = true
+	w.queue = nil
+	w.mu.Unlock()
+}
+
+// close stops the watcher. The channel of a WatchBlock watcher is closed
+// once the events queued so far are received.
+func (w *Watcher[K, V]) close() {
+	if w.policy != WatchBlock {
+		close(w.c)
+		return
+	}
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.signal()
+}
+
+// Watch subscribes to the changes of the map matching the options. Events are
+// sent after the lock of the changed shard is released, in the order of the
+// changes for each key.
+func (m *TypedMap[K, V]) Watch(ctx context.Context, opts *WatchOptions[K]) *Watcher[K, V] {
+	if opts == nil {
+		opts = &WatchOptions[K]{}
+	}
+	size := opts.BufferSize
+	if size < 1 {
+		size = 64
+	}
+	w := &Watcher[K, V]{
+		ctx:    ctx,
+		c:      make(chan TypedEvent[K, V], size),
+		prefix: opts.Prefix,
+		policy: opts.Policy,
+	}
+	if opts.Keys != nil {
+		w.keys = make(map[K]struct{}, len(opts.Keys))
+		for _, key := range opts.Keys {
+			w.keys[key] = struct{}{}
+		}
+	}
+	if opts.Policy == WatchBlock {
+		w.size = size
+		w.wake = make(chan struct{}, 1)
+		w.overflow = make(chan struct{})
+		go w.run()
+	}
+	if !m.hub.add(w) {
+		w.close()
+		return w
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.hub.remove(w)
+		case <-w.overflow:
+			m.hub.remove(w)
+		case <-m.drainingChan:
+		}
+	}()
+	return w
+}
+
+// hub publishes events to the watchers of a map.
+type hub[K comparable, V any] struct {
+	mu       sync.RWMutex
+	watchers map[*Watcher[K, V]]struct{}
+	closed   bool
+	n        atomic.Int32
+}
+
+func (h *hub[K, V]) active() bool {
+	return h.n.Load() > 0
+}
+
+func (h *hub[K, V]) add(w *Watcher[K, V]) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	if h.watchers == nil {
+		h.watchers = make(map[*Watcher[K, V]]struct{})
+	}
+	h.watchers[w] = struct{}{}
+	h.n.Add(1)
+	return true
+}
+
+func (h *hub[K, V]) remove(w *Watcher[K, V]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.watchers[w]; ok {
+		delete(h.watchers, w)
+		h.n.Add(-1)
+		w.close()
+	}
+}
+
+func (h *hub[K, V]) publish(events []TypedEvent[K, V]) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for w := range h.watchers {
+		for i := range events {
+			if w.match(&events[i]) {
+				w.send(events[i])
+			}
+		}
+	}
+}
+
+// close sends EventDrain to every watcher and closes their channels.
+func (h *hub[K, V]) close() {
+	h.publish([]TypedEvent[K, V]{{Type: EventDrain}})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for w := range h.watchers {
+		delete(h.watchers, w)
+		w.close()
+	}
+	h.n.Store(0)
+}
@@ -0,0 +1,206 @@
+package ttlmap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMapWatch(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	m := New(&Options{Clock: clock, Shards: 2})
+	defer m.Drain()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all := m.Watch(ctx, nil)
+	prefixed := m.Watch(ctx, &WatchOptions[string]{Prefix: "user/"})
+	exact := m.Watch(ctx, &WatchOptions[string]{Keys: []string{"foo"}})
+
+	foo := NewItem("hello", nil)
+	foo2 := NewItem("world", nil)
+	user := NewItem(1, WithTTLFrom(clock, time.Second))
+	if err := m.Set("foo", foo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update("foo", foo2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("user/1", user, nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Second)
+	if _, err := m.Delete("foo"); err != nil {
+		t.Fatal(err)
+	}
+	m.Drain()
+
+	expectEvents(t, all.Events(), []TypedEvent[string, interface{}]{
+		{Type: EventSet, Key: "foo", NewItem: foo},
+		{Type: EventUpdate, Key: "foo", OldItem: foo, NewItem: foo2},
+		{Type: EventSet, Key: "user/1", NewItem: user},
+		{Type: EventExpire, Key: "user/1", OldItem: user},
+		{Type: EventEvict, Key: "user/1", OldItem: user},
+		{Type: EventDelete, Key: "foo", OldItem: foo2},
+		{Type: EventDrain},
+	})
+	expectEvents(t, prefixed.Events(), []TypedEvent[string, interface{}]{
+		{Type: EventSet, Key: "user/1", NewItem: user},
+		{Type: EventExpire, Key: "user/1", OldItem: user},
+		{Type: EventEvict, Key: "user/1", OldItem: user},
+		{Type: EventDrain},
+	})
+	expectEvents(t, exact.Events(), []TypedEvent[string, interface{}]{
+		{Type: EventSet, Key: "foo", NewItem: foo},
+		{Type: EventUpdate, Key: "foo", OldItem: foo, NewItem: foo2},
+		{Type: EventDelete, Key: "foo", OldItem: foo2},
+		{Type: EventDrain},
+	})
+	if w := m.Watch(ctx, nil); w.Events() == nil {
+		t.Fatalf("Expecting channel")
+	} else if _, ok := <-w.Events(); ok {
+		t.Fatalf("Expecting closed channel after drain")
+	}
+}
+
+func expectEvents(t *testing.T, c <-chan Event, expected []Event) {
+	t.Helper()
+	for i, want := range expected {
+		got, ok := <-c
+		if !ok {
+			t.Fatalf("Missing event %d: %+v", i, want)
+		}
+		if got != want {
+			t.Fatalf("Invalid event %d: %+v, expecting %+v", i, got, want)
+		}
+	}
+	if ev, ok := <-c; ok {
+		t.Fatalf("Unexpected event %+v", ev)
+	}
+}
+
+func TestMapWatchDrop(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := m.Watch(ctx, &WatchOptions[string]{BufferSize: 2})
+	for i := 0; i < 5; i++ {
+		if err := m.Set("foo", NewItem(i, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each replacing set sends an evict and a set event.
+	if w.Dropped() != 7 {
+		t.Fatalf("Invalid dropped %d", w.Dropped())
+	}
+	cancel()
+	for range w.Events() {
+	}
+}
+
+func TestMapWatchBlock(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The channel and the queue hold the 9 events.
+	w := m.Watch(ctx, &WatchOptions[string]{BufferSize: 5, Policy: WatchBlock})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			if err := m.Set("foo", NewItem(i, nil), nil); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	n := 0
+	for ev := range w.Events() {
+		if ev.Type == EventSet {
+			n++
+		}
+		if n == 5 {
+			break
+		}
+	}
+	<-done
+	if w.Dropped() != 0 {
+		t.Fatalf("Not expecting dropped events")
+	}
+	// The map stays usable while the blocked watcher is not reading.
+	if _, err := m.Get("foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapWatchBlockStalled(t *testing.T) {
+	m := New(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := m.Watch(ctx, &WatchOptions[string]{BufferSize: 8, Policy: WatchBlock})
+	if err := m.Set("other", NewItem("other", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Nobody reads the events, so the channel stays full.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Set("foo", NewItem(i, nil), nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg.Wait()
+		if _, err := m.Get("other"); err != nil {
+			t.Error(err)
+		}
+		m.Drain()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Map blocked by a stalled watcher")
+	}
+	// The queued events are still delivered, up to EventDrain.
+	var last Event
+	for ev := range w.Events() {
+		last = ev
+	}
+	if last.Type != EventDrain {
+		t.Fatalf("Expecting EventDrain, got %v", last.Type)
+	}
+}
+
+func TestMapWatchBlockOverflow(t *testing.T) {
+	m := New(nil)
+	defer m.Drain()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := m.Watch(ctx, &WatchOptions[string]{BufferSize: 2, Policy: WatchBlock})
+	for i := 0; i < 10; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The events in the channel and the queue are received before the
+	// channel is closed.
+	n := 0
+	for ev := range w.Events() {
+		if ev.Type != EventSet || ev.Key != fmt.Sprintf("%d", n) {
+			t.Fatalf("Invalid event %v", ev)
+		}
+		n++
+	}
+	if n < 2 || n > 5 {
+		t.Fatalf("Invalid number of events %d", n)
+	}
+	if err := w.Err(); err != ErrWatcherOverflow {
+		t.Fatalf("Expecting ErrWatcherOverflow, got %v", err)
+	}
+}
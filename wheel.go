@@ -0,0 +1,307 @@
+package ttlmap
+
+import (
+	"math/bits"
+	"time"
+)
+
+// TimingWheelOptions selects a hierarchical timing wheel to schedule
+// expirations, instead of the default heap.
+//
+// Setting, updating and deleting items then takes constant time instead of
+// logarithmic time, and the keeper wakes up at most once per tick rather than
+// every time the next expiration changes. In exchange, items expire up to one
+// Resolution late, and ExpiringBefore and ExpiryBacklog scan every item.
+// Without an EvictionPolicy, a full map evicts an item of the earliest slot
+// of the wheel, which may expire somewhat later than the first one.
+type TimingWheelOptions struct {
+	// Resolution is the duration of a tick of the wheel. Defaults to one
+	// millisecond.
+	Resolution time.Duration
+}
+
+const (
+	wheelBits  = 6
+	wheelSlots = 1 << wheelBits
+	// wheelLevels is enough for every int64 tick.
+	wheelLevels = (64 + wheelBits - 1) / wheelBits
+	// readyBucket holds the items due by the current tick, and neverBucket
+	// the items that do not expire.
+	readyBucket = wheelLevels * wheelSlots
+	neverBucket = readyBucket + 1
+)
+
+// timingWheel is a hierarchical timing wheel. Level 0 has a slot per tick
+// for the ticks sharing the upper bits of the current tick; each level above
+// has a slot per block of slots of the level below. Slots are cascaded down
+// when the current tick reaches them, and items are expired from level 0.
+type timingWheel[K comparable, V any] struct {
+	resolution int64
+	// tick is the last tick the wheel advanced to.
+	tick     int64
+	buckets  [neverBucket + 1][]*pqitem[K, V]
+	occupied [wheelLevels]uint64
+	n        int
+	// wake is the tick the keeper is scheduled for, if not idle.
+	wake int64
+	idle bool
+}
+
+func newTimingWheel[K comparable, V any](opts *TimingWheelOptions, now time.Time) *timingWheel[K, V] {
+	w := &timingWheel[K, V]{
+		resolution: int64(opts.Resolution),
+		idle:       true,
+	}
+	if w.resolution <= 0 {
+		w.resolution = int64(time.Millisecond)
+	}
+	w.tick = w.floor(now)
+	return w
+}
+
+// floor returns the last tick at or before t.
+func (w *timingWheel[K, V]) floor(t time.Time) int64 {
+	ns := t.UnixNano()
+	tick := ns / w.resolution
+	if ns%w.resolution < 0 {
+		tick--
+	}
+	return tick
+}
+
+// ceil returns the first tick at or after t.
+func (w *timingWheel[K, V]) ceil(t time.Time) int64 {
+	ns := t.UnixNano()
+	tick := ns / w.resolution
+	if ns%w.resolution > 0 {
+		tick++
+	}
+	return tick
+}
+
+func (w *timingWheel[K, V]) push(pqi *pqitem[K, V]) {
+	w.n++
+	if !pqi.item.expires {
+		w.add(neverBucket, pqi)
+		return
+	}
+	if tick := w.ceil(pqi.item.expiration); tick > w.tick {
+		w.place(pqi, tick)
+	} else {
+		w.add(readyBucket, pqi)
+	}
+}
+
+// place adds an item due at the given tick, which is not before the current
+// one, to the slot of the lowest level sharing the upper bits of the current
+// tick.
+func (w *timingWheel[K, V]) place(pqi *pqitem[K, V], tick int64) {
+	level := 0
+	for ; level < wheelLevels-1; level++ {
+		shift := wheelBits * (level + 1)
+		if tick>>shift == w.tick>>shift {
+			break
+		}
+	}
+	slot := int(tick>>(wheelBits*level)) & (wheelSlots - 1)
+	w.occupied[level] |= 1 << slot
+	w.add(level*wheelSlots+slot, pqi)
+}
+
+// cascade re-places the items of the slot of a level above 0 that starts at
+// the current tick. Items due at the current tick land in level 0.
+func (w *timingWheel[K, V]) cascade(level int) {
+	slot := int(w.tick>>(wheelBits*level)) & (wheelSlots - 1)
+	w.occupied[level] &^= 1 << slot
+	b := level*wheelSlots + slot
+	items := w.buckets[b]
+	w.buckets[b] = nil
+	for _, pqi := range items {
+		w.place(pqi, w.ceil(pqi.item.expiration))
+	}
+}
+
+func (w *timingWheel[K, V]) add(b int, pqi *pqitem[K, V]) {
+	pqi.bucket = b
+	pqi.index = len(w.buckets[b])
+	w.buckets[b] = append(w.buckets[b], pqi)
+}
+
+func (w *timingWheel[K, V]) remove(pqi *pqitem[K, V]) {
+	w.n--
+	w.unlink(pqi)
+}
+
+func (w *timingWheel[K, V]) unlink(pqi *pqitem[K, V]) {
+	b := pqi.bucket
+	items := w.buckets[b]
+	last := items[len(items)-1]
+	items[pqi.index] = last
+	last.index = pqi.index
+	items[len(items)-1] = nil
+	w.buckets[b] = items[:len(items)-1]
+	if len(w.buckets[b]) == 0 && b < readyBucket {
+		w.occupied[b/wheelSlots] &^= 1 << (b % wheelSlots)
+	}
+	pqi.index = -1
+}
+
+func (w *timingWheel[K, V]) fix(pqi *pqitem[K, V]) {
+	w.remove(pqi)
+	w.push(pqi)
+}
+
+// event returns the tick at which the wheel has to advance for the item.
+func (w *timingWheel[K, V]) event(pqi *pqitem[K, V]) (int64, bool) {
+	switch b := pqi.bucket; {
+	case b == neverBucket:
+		return 0, false
+	case b == readyBucket:
+		return w.tick, true
+	default:
+		level, slot := b/wheelSlots, int64(b%wheelSlots)
+		shift := wheelBits * (level + 1)
+		return w.tick>>shift<<shift | slot<<(wheelBits*level), true
+	}
+}
+
+func (w *timingWheel[K, V]) head(pqi *pqitem[K, V]) bool {
+	tick, ok := w.event(pqi)
+	return ok && (w.idle || tick < w.wake)
+}
+
+// nextEvent returns the first tick at which a slot has to be cascaded or
+// expired.
+func (w *timingWheel[K, V]) nextEvent() (int64, bool) {
+	for level := 0; level < wheelLevels; level++ {
+		if w.occupied[level] == 0 {
+			continue
+		}
+		// Occupied slots are always after the current one.
+		slot := int64(bits.TrailingZeros64(w.occupied[level]))
+		shift := wheelBits * (level + 1)
+		return w.tick>>shift<<shift | slot<<(wheelBits*level), true
+	}
+	return 0, false
+}
+
+// advance moves the wheel to the given tick, moving the items due by then to
+// the ready bucket.
+func (w *timingWheel[K, V]) advance(tick int64) {
+	for w.tick < tick {
+		next, ok := w.nextEvent()
+		if !ok || next > tick {
+			w.tick = tick
+			return
+		}
+		w.tick = next
+		for level := wheelLevels - 1; level > 0; level-- {
+			if next&(1<<(wheelBits*level)-1) == 0 {
+				w.cascade(level)
+			}
+		}
+		slot := int(next) & (wheelSlots - 1)
+		if w.occupied[0]&(1<<slot) == 0 {
+			continue
+		}
+		w.occupied[0] &^= 1 << slot
+		for _, pqi := range w.buckets[slot] {
+			w.add(readyBucket, pqi)
+		}
+		w.buckets[slot] = nil
+	}
+}
+
+func (w *timingWheel[K, V]) expired(now time.Time) *pqitem[K, V] {
+	w.advance(w.floor(now))
+	for ready := w.buckets[readyBucket]; len(ready) > 0; ready = w.buckets[readyBucket] {
+		pqi := ready[len(ready)-1]
+		if pqi.item.ExpiredAt(now) {
+			return pqi
+		}
+		// Expiring exactly now, so due at the next tick.
+		w.unlink(pqi)
+		w.place(pqi, w.tick+1)
+	}
+	return nil
+}
+
+func (w *timingWheel[K, V]) next(now time.Time) (time.Duration, bool) {
+	tick, ok := w.tick, len(w.buckets[readyBucket]) > 0
+	if !ok {
+		tick, ok = w.nextEvent()
+	}
+	w.wake, w.idle = tick, !ok
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(tick*w.resolution - now.UnixNano()), true
+}
+
+// first returns the bucket holding the items expiring first.
+func (w *timingWheel[K, V]) first() int {
+	if len(w.buckets[readyBucket]) > 0 {
+		return readyBucket
+	}
+	for level := 0; level < wheelLevels; level++ {
+		if w.occupied[level] != 0 {
+			return level*wheelSlots + bits.TrailingZeros64(w.occupied[level])
+		}
+	}
+	return neverBucket
+}
+
+func (w *timingWheel[K, V]) peek() *pqitem[K, V] {
+	b := w.first()
+	if b == neverBucket {
+		return w.victim()
+	}
+	var first *pqitem[K, V]
+	for _, pqi := range w.buckets[b] {
+		if first == nil || pqi.item.expiration.Before(first.item.expiration) {
+			first = pqi
+		}
+	}
+	return first
+}
+
+// victim returns the last item of the first bucket, without scanning it.
+func (w *timingWheel[K, V]) victim() *pqitem[K, V] {
+	items := w.buckets[w.first()]
+	if len(items) == 0 {
+		return nil
+	}
+	return items[len(items)-1]
+}
+
+func (w *timingWheel[K, V]) len() int {
+	return w.n
+}
+
+func (w *timingWheel[K, V]) each(fn func(pqi *pqitem[K, V]) bool) {
+	for b := range w.buckets {
+		for _, pqi := range w.buckets[b] {
+			if !fn(pqi) {
+				return
+			}
+		}
+	}
+}
+
+func (w *timingWheel[K, V]) before(t time.Time, fn func(pqi *pqitem[K, V])) {
+	w.each(func(pqi *pqitem[K, V]) bool {
+		if pqi.item.expires && pqi.item.expiration.Before(t) {
+			fn(pqi)
+		}
+		return true
+	})
+}
+
+func (w *timingWheel[K, V]) clear() {
+	for b := range w.buckets {
+		clear(w.buckets[b])
+		w.buckets[b] = w.buckets[b][:0]
+	}
+	w.occupied = [wheelLevels]uint64{}
+	w.n = 0
+}
@@ -0,0 +1,195 @@
+package ttlmap
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimingWheel(t *testing.T) {
+	const resolution = time.Millisecond
+	now := time.Unix(1000, 0)
+	w := newTimingWheel[int, int](&TimingWheelOptions{Resolution: resolution}, now)
+	live := make(map[int]*pqitem[int, int])
+	push := func(key int) {
+		// TTLs from already expired to several days, to reach the upper levels.
+		ttl := time.Duration(rand.Int63n(int64(time.Duration(1)<<(rand.Intn(49))))) - time.Second
+		item := NewTypedItem(key, WithTTLFrom(NewFakeClock(now), ttl))
+		if key%100 == 0 {
+			item = NewTypedItem(key, nil)
+		}
+		pqi := &pqitem[int, int]{key: key, item: &item}
+		w.push(pqi)
+		live[key] = pqi
+	}
+	for i := 0; i < 10000; i++ {
+		push(i)
+	}
+	key := 10000
+	for step := 0; step < 2000; step++ {
+		now = now.Add(time.Duration(rand.Int63n(int64(time.Duration(1) << (rand.Intn(40))))))
+		for i := 0; i < 5; i++ {
+			push(key)
+			key++
+		}
+		for k, pqi := range live {
+			if rand.Intn(100) == 0 {
+				w.remove(pqi)
+				delete(live, k)
+			} else if rand.Intn(100) == 0 {
+				pqi.item.expiration = now.Add(time.Duration(rand.Int63n(int64(time.Hour))))
+				w.fix(pqi)
+			}
+		}
+		for pqi := w.expired(now); pqi != nil; pqi = w.expired(now) {
+			if !pqi.item.ExpiredAt(now) || live[pqi.key] != pqi {
+				t.Fatalf("Invalid expired item %d at %v: %v", pqi.key, now, pqi.item.expiration)
+			}
+			w.remove(pqi)
+			delete(live, pqi.key)
+		}
+		var first *pqitem[int, int]
+		for _, pqi := range live {
+			if pqi.item.expires && !pqi.item.expiration.After(now.Add(-resolution)) {
+				t.Fatalf("Item %d not expired at %v: %v", pqi.key, now, pqi.item.expiration)
+			}
+			if first == nil || (pqi.item.expires && (!first.item.expires || pqi.item.expiration.Before(first.item.expiration))) {
+				first = pqi
+			}
+		}
+		if peek := w.peek(); first != nil && (peek == nil || peek.item.expiration != first.item.expiration) {
+			t.Fatalf("Invalid peek %v, expecting %v", peek, first)
+		}
+		if w.len() != len(live) {
+			t.Fatalf("Invalid length %d, expecting %d", w.len(), len(live))
+		}
+	}
+}
+
+func TestMapTimingWheel(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	var expired atomic.Int32
+	m := New(&Options{
+		Clock:       clock,
+		Shards:      2,
+		TimingWheel: &TimingWheelOptions{Resolution: 10 * time.Millisecond},
+		OnWillExpire: func(key string, item Item) {
+			expired.Add(1)
+		},
+	})
+	defer m.Drain()
+	for i := 1; i <= 10; i++ {
+		if err := m.Set(fmt.Sprintf("%d", i), NewItem(i, WithTTLFrom(clock, time.Duration(i)*time.Second)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set("never", NewItem("never", nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	key, expiration, ok := m.NextExpiration()
+	if !ok || key != "1" || !expiration.Equal(time.Unix(1, 0)) {
+		t.Fatalf("Invalid key=%s expiration=%v ok=%v", key, expiration, ok)
+	}
+	clock.Advance(5500 * time.Millisecond)
+	if n := expired.Load(); n != 5 {
+		t.Fatalf("Invalid expired count: %d", n)
+	}
+	if _, err := m.Update("6", NewItem(6, WithTTLFrom(clock, time.Hour)), nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Second)
+	if n := expired.Load(); n != 9 {
+		t.Fatalf("Invalid expired count: %d", n)
+	}
+	if n := m.Len(); n != 2 {
+		t.Fatalf("Invalid length: %d", n)
+	}
+}
+
+func TestMapTimingWheelEvict(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	m := New(&Options{
+		Clock:       clock,
+		MaxItems:    3,
+		TimingWheel: &TimingWheelOptions{},
+	})
+	defer m.Drain()
+	for _, key := range []string{"never", "2", "1", "3"} {
+		item := NewItem(key, nil)
+		if key != "never" {
+			ttl, _ := time.ParseDuration(key + "h")
+			item = NewItem(key, WithTTLFrom(clock, ttl))
+		}
+		if err := m.Set(key, item, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The items are in different slots, so the first one to expire goes.
+	if _, err := m.Get("1"); err != ErrNotExist {
+		t.Fatalf("Expecting 1 to be evicted, got %v", err)
+	}
+	for _, key := range []string{"4", "5"} {
+		if err := m.Set(key, NewItem(key, nil), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := m.Keys(); len(keys) != 3 {
+		t.Fatalf("Invalid keys: %v", keys)
+	}
+	for _, key := range []string{"2", "3"} {
+		if _, err := m.Get(key); err != ErrNotExist {
+			t.Fatalf("Expecting %s to be evicted, got %v", key, err)
+		}
+	}
+}
+
+// large enables the benchmarks on maps of millions of items, run by make
+// bench.
+var large = flag.Bool("large", false, "run the benchmarks on large maps")
+
+// BenchmarkMapScheduler sets items with short TTLs in maps already holding
+// 10k, 1M and 10M items, with the heap and the timing wheel. The larger maps
+// are skipped unless -large is set.
+func BenchmarkMapScheduler(b *testing.B) {
+	for _, n := range []int{10000, 1000000, 10000000} {
+		for _, wheel := range []bool{false, true} {
+			name := fmt.Sprintf("heap/%d", n)
+			opts := &Options{InitialCapacity: n}
+			if wheel {
+				name = fmt.Sprintf("wheel/%d", n)
+				opts.TimingWheel = &TimingWheelOptions{}
+			}
+			b.Run(name, func(b *testing.B) {
+				if n > 10000 && !*large {
+					b.Skip("skipping large map without -large")
+				}
+				benchmarkMapScheduler(b, opts, n)
+			})
+		}
+	}
+}
+
+func benchmarkMapScheduler(b *testing.B, opts *Options, n int) {
+	b.StopTimer()
+	m := New(opts)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d", i)
+		ttl := time.Minute + time.Duration(rand.Int63n(int64(time.Minute)))
+		if err := m.Set(keys[i], NewItem(i, WithTTL(ttl)), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		ttl := time.Minute + time.Duration(rand.Int63n(int64(time.Minute)))
+		if err := m.Set(keys[i%n], NewItem(i, WithTTL(ttl)), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	m.Drain()
+}